@@ -1,18 +1,28 @@
 package cli
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"q/config"
+	"q/environment"
+	"q/history"
 	"q/llm"
+	"q/risk"
 	. "q/types"
+	"q/usage"
 	"q/util"
 
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/atotto/clipboard"
 	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/glamour"
@@ -26,48 +36,154 @@ const (
 	Loading State = iota
 	RecevingInput
 	ReceivingResponse
+	ConfirmingExecution
+	EditingCommand
+	ConfirmingCopy
 )
 
 type model struct {
+	appConfig        config.AppConfig
 	client           *llm.LLMClient
+	ledger           *usage.Ledger
+	session          *history.Session
 	markdownRenderer *glamour.TermRenderer
 	p                *tea.Program
 
 	textInput textinput.Model
+	textArea  textarea.Model
 	spinner   spinner.Model
 
 	state                 State
 	query                 string
 	latestCommandResponse string
 	latestCommandIsCode   bool
+	latestRisk            risk.Report
+	confirmationsLeft     int
 
 	formattedPartialResponse string
 
 	maxWidth int
 
 	runWithArgs bool
+	notices     []string
+	resumed     bool
+	fixing      string
+	mode        string
 	err         error
+
+	// widget is set when running from a shell keybinding, where accepting
+	// the command hands it back to the shell instead of copying it.
+	widget   bool
+	accepted string
+
+	// cancel aborts the in-flight query, initialQuery is started by Init.
+	cancel       context.CancelFunc
+	cancelling   bool
+	initialQuery tea.Cmd
+
+	retryReason string
+	retryAt     time.Time
+
+	// explainedRisk is the risk of the command q explain was given, shown
+	// once under the first explanation.
+	explainedRisk risk.Report
+
+	sessionUsage usage.Totals
 }
 
 type responseMsg struct {
 	response string
-	err      error
+	model    string
+	usage    *usage.Record
+	// budgetWarnings lists the budgets that are close to being spent.
+	budgetWarnings []string
+	// trimmed is what was left out to fit the context window.
+	trimmed llm.Trimming
+	err     error
 }
 type partialResponseMsg struct {
 	content string
 	err     error
 }
+type retryMsg struct {
+	reason string
+	wait   time.Duration
+}
 type setPMsg struct{ p *tea.Program }
+type commandFinishedMsg struct{ err error }
 
 // === Commands === //
 
-func makeQuery(client *llm.LLMClient, query string) tea.Cmd {
+func makeQuery(ctx context.Context, client *llm.LLMClient, ledger *usage.Ledger, session *history.Session, query string) tea.Cmd {
 	return func() tea.Msg {
-		response, err := client.Query(query)
-		return responseMsg{response: response, err: err}
+		response, err := client.Query(ctx, query)
+		msg := responseMsg{response: response, model: client.AnsweredBy(), trimmed: client.Trimmed(), err: err}
+		// cancelled and half-streamed answers count too, if the provider
+		// reported their usage
+		msg.usage, msg.budgetWarnings = recordUsage(ledger, client)
+		if response != "" {
+			saveTurn(session, client, query, response)
+		}
+		return msg
 	}
 }
 
+// saveTurn adds the answered query to the session and saves it to disk.
+func saveTurn(session *history.Session, client *llm.LLMClient, query, response string) {
+	var command string
+	if session.Mode != config.ExplainMode {
+		command, _ = util.ExtractFirstCodeBlock(response)
+	}
+	session.AddTurn(history.Turn{
+		Time:     time.Now(),
+		Query:    query,
+		Response: response,
+		Command:  command,
+		Model:    client.AnsweredBy(),
+	})
+	session.Messages = client.Messages()
+	saveBestEffort(session.Save)
+}
+
+// saveBestEffort writes history or usage to disk. Both are best effort, a
+// failed write shouldn't get in the way.
+func saveBestEffort(save func() error) {
+	_ = save()
+}
+
+// recordUsage adds the last query to the usage ledger, and returns it along
+// with any budget warnings. The record is nil if the provider didn't report
+// usage.
+func recordUsage(ledger *usage.Ledger, client *llm.LLMClient) (*usage.Record, []string) {
+	modelConfig, u, ok := client.LastUsage()
+	if !ok {
+		return nil, nil
+	}
+	record := usage.NewRecord(modelConfig, u)
+	saveBestEffort(func() error { return ledger.Add(record) })
+	return &record, ledger.Warnings(modelConfig)
+}
+
+// trimmingNotices tells the user what was left out of a query to fit the
+// context window.
+func trimmingNotices(t llm.Trimming) []string {
+	var notices []string
+	if t.Shortened > 0 {
+		notices = append(notices, "The attached context was cut short to fit the context window (set context_window on the model if it's larger).")
+	}
+	if t.Dropped > 0 {
+		notices = append(notices, fmt.Sprintf("%d earlier messages were condensed to fit the context window.", t.Dropped))
+	}
+	return notices
+}
+
+func runCommand(command string) tea.Cmd {
+	c := util.ShellCommand(command)
+	return tea.ExecProcess(c, func(err error) tea.Msg {
+		return commandFinishedMsg{err}
+	})
+}
+
 // === Msg Handlers === //
 
 func (m model) handleKeyEnter() (tea.Model, tea.Cmd) {
@@ -81,18 +197,15 @@ func (m model) handleKeyEnter() (tea.Model, tea.Cmd) {
 		if m.latestCommandResponse == "" {
 			return m, tea.Quit
 		}
-		err := clipboard.WriteAll(m.latestCommandResponse)
-		if err != nil {
-			fmt.Println("Failed to copy text to clipboard:", err)
-			return m, tea.Quit
-		}
-		placeholderStyle := lipgloss.NewStyle().Faint(true)
-		message := "Copied to clipboard."
-		if !m.latestCommandIsCode {
-			message = "Copied only code to clipboard."
+		if m.latestRisk.Level >= risk.High {
+			m.state = ConfirmingCopy
+			m.confirmationsLeft = 1
+			return m, nil
 		}
-		message = placeholderStyle.Render(message)
-		return m, tea.Sequence(tea.Printf("%s", message), tea.Quit)
+		return m.copyAndQuit()
+	}
+	if v == "/mode" || strings.HasPrefix(v, "/mode ") {
+		return m.handleModeCommand(strings.TrimSpace(strings.TrimPrefix(v, "/mode")))
 	}
 	// Input, run query.
 	m.textInput.SetValue("")
@@ -100,7 +213,215 @@ func (m model) handleKeyEnter() (tea.Model, tea.Cmd) {
 	m.state = Loading
 	placeholderStyle := lipgloss.NewStyle().Faint(true).Width(m.maxWidth)
 	message := placeholderStyle.Render(fmt.Sprintf("> %s", v))
-	return m, tea.Sequence(tea.Printf("%s", message), tea.Batch(m.spinner.Tick, makeQuery(m.client, m.query)))
+	return m, tea.Sequence(tea.Printf("%s", message), tea.Batch(m.spinner.Tick, m.newQuery(m.query)))
+}
+
+// handleModeCommand switches the rest of the session to the named mode, or
+// lists the modes when no name is given.
+func (m model) handleModeCommand(name string) (tea.Model, tea.Cmd) {
+	m.textInput.SetValue("")
+	styleDim := lipgloss.NewStyle().Faint(true)
+	echo := styleDim.Width(m.maxWidth).Render(strings.TrimSpace("> /mode " + name))
+	if name == "" {
+		return m, tea.Sequence(tea.Printf("%s", echo), tea.Printf("%s", m.getModeList()))
+	}
+	mode, err := getMode(m.appConfig, name)
+	if err != nil {
+		styleRed := lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+		return m, tea.Sequence(tea.Printf("%s", echo), tea.Printf("\n  %s\n", styleRed.Render("Error: "+err.Error())))
+	}
+	m.client.SetPrompt(mode.Prompt)
+	m.mode = mode.Name
+	m.session.Mode = mode.Name
+	if len(m.session.Turns) > 0 {
+		saveBestEffort(m.session.Save)
+	}
+	return m, tea.Sequence(tea.Printf("%s", echo), tea.Printf("%s", styleDim.Render("Switched to "+modeName(mode.Name)+" mode.")))
+}
+
+// getModeList lists the modes that /mode can switch to, marking the
+// current one.
+func (m model) getModeList() string {
+	styleDim := lipgloss.NewStyle().Faint(true)
+	styleCurrent := lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
+	names := m.appConfig.ModeNames()
+	width := 0
+	for _, name := range names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	lines := []string{styleDim.Render("Modes, switch with /mode <name>:")}
+	for _, name := range names {
+		description := "the model's own prompt"
+		if mode, ok := m.appConfig.FindMode(name); ok {
+			description = mode.Description
+		}
+		line := fmt.Sprintf("  %-*s  %s", width, name, styleDim.Render(description))
+		if name == modeName(m.mode) {
+			line = fmt.Sprintf("  %s  %s", styleCurrent.Render(fmt.Sprintf("%-*s", width, name)), styleDim.Render(description))
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// modeName names a session's mode, which is empty for the default one.
+func modeName(mode string) string {
+	if mode == "" {
+		return config.DefaultMode
+	}
+	return mode
+}
+
+func (m *model) newQuery(query string) tea.Cmd {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.cancelling = false
+	m.retryReason = ""
+	return makeQuery(ctx, m.client, m.ledger, m.session, query)
+}
+
+// handleKeyCancel aborts the in-flight query on the first CTRL+C, and quits
+// on the second.
+func (m model) handleKeyCancel() (tea.Model, tea.Cmd) {
+	inFlight := m.state == Loading || m.state == ReceivingResponse
+	if !inFlight || m.cancelling || m.cancel == nil {
+		return m, tea.Quit
+	}
+	m.cancel()
+	m.cancelling = true
+	return m, nil
+}
+
+func (m model) copyAndQuit() (tea.Model, tea.Cmd) {
+	if m.widget {
+		m.accepted = m.latestCommandResponse
+		return m, tea.Quit
+	}
+	err := clipboard.WriteAll(m.latestCommandResponse)
+	if err != nil {
+		fmt.Println("Failed to copy text to clipboard:", err)
+		return m, tea.Quit
+	}
+	placeholderStyle := lipgloss.NewStyle().Faint(true)
+	message := "Copied to clipboard."
+	if !m.latestCommandIsCode {
+		message = "Copied only code to clipboard."
+	}
+	message = placeholderStyle.Render(message)
+	return m, tea.Sequence(tea.Printf("%s", message), tea.Quit)
+}
+
+func (m model) handleKeyRun() (tea.Model, tea.Cmd) {
+	if m.state != RecevingInput || m.latestCommandResponse == "" {
+		return m, nil
+	}
+	m.state = ConfirmingExecution
+	m.confirmationsLeft = 1
+	if m.latestRisk.Level >= risk.High {
+		m.confirmationsLeft = 2
+	}
+	return m, nil
+}
+
+func (m model) handleConfirmKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if msg.Type != tea.KeyRunes || strings.ToLower(string(msg.Runes)) != "y" {
+		m.state = RecevingInput
+		return m, textinput.Blink
+	}
+	m.confirmationsLeft--
+	if m.confirmationsLeft > 0 {
+		return m, nil
+	}
+	if m.state == ConfirmingCopy {
+		return m.copyAndQuit()
+	}
+	m.state = RecevingInput
+	placeholderStyle := lipgloss.NewStyle().Faint(true).Width(m.maxWidth)
+	message := placeholderStyle.Render(fmt.Sprintf("$ %s", m.latestCommandResponse))
+	return m, tea.Sequence(tea.Printf("%s", message), runCommand(m.latestCommandResponse))
+}
+
+func (m model) handleCommandFinishedMsg(msg commandFinishedMsg) (tea.Model, tea.Cmd) {
+	styleDim := lipgloss.NewStyle().Faint(true)
+	styleRed := lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	message := styleDim.Render("Exited with status 0.")
+	var exitErr *exec.ExitError
+	if errors.As(msg.err, &exitErr) {
+		message = styleRed.Render(fmt.Sprintf("Exited with status %d.", exitErr.ExitCode()))
+	} else if msg.err != nil {
+		message = styleRed.Render(fmt.Sprintf("Failed to run command: %v", msg.err))
+	}
+	m.state = RecevingInput
+	return m, tea.Sequence(tea.Printf("%s\n", message), textinput.Blink)
+}
+
+func (m model) handleKeyEdit() (tea.Model, tea.Cmd) {
+	if m.state != RecevingInput || m.latestCommandResponse == "" {
+		return m, nil
+	}
+	m.state = EditingCommand
+	m.textArea.SetValue(m.latestCommandResponse)
+	m.textArea.SetHeight(util.Clamp(m.textArea.LineCount(), 1, 10))
+	return m, m.textArea.Focus()
+}
+
+func (m model) handleEditingKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.textArea.Blur()
+		m.state = RecevingInput
+		return m, textinput.Blink
+
+	case tea.KeyCtrlS:
+		return m.handleEditAccepted()
+	}
+	m.textArea, cmd = m.textArea.Update(msg)
+	m.textArea.SetHeight(util.Clamp(m.textArea.LineCount(), 1, 10))
+	return m, cmd
+}
+
+func (m model) handleEditAccepted() (tea.Model, tea.Cmd) {
+	m.textArea.Blur()
+	m.state = RecevingInput
+	edited := strings.TrimSpace(m.textArea.Value())
+	if edited == "" || edited == m.latestCommandResponse {
+		return m, textinput.Blink
+	}
+	m.latestCommandResponse = edited
+	m.latestCommandIsCode = true
+	m.latestRisk = risk.Analyze(edited)
+	m.setFollowUpPlaceholder()
+
+	// let follow-ups know what the user actually went with
+	codeBlock := fmt.Sprintf("```\n%s\n```", edited)
+	m.client.AddMessage(Message{
+		Role:    "user",
+		Content: "I edited the command to:\n" + codeBlock,
+	})
+	if n := len(m.session.Turns); n > 0 {
+		m.session.Turns[n-1].Command = edited
+		m.session.Messages = m.client.Messages()
+		saveBestEffort(m.session.Save)
+	}
+	formatted := m.formatOrRaw(codeBlock, true)
+	return m, tea.Sequence(tea.Printf("%s%s", formatted, m.getRiskBanner(m.latestRisk)), textinput.Blink)
+}
+
+func (m *model) setFollowUpPlaceholder() {
+	accept, acceptCode := "copy & quit", "copy (code only)"
+	if m.widget {
+		accept, acceptCode = "insert & quit", "insert (code only)"
+	}
+	m.textInput.Placeholder = "Follow up, ENTER to " + accept + ", CTRL+R to run, CTRL+O to edit, CTRL+C to quit"
+	if !m.latestCommandIsCode {
+		m.textInput.Placeholder = "Follow up, ENTER to " + acceptCode + ", CTRL+R to run, CTRL+O to edit, CTRL+C to quit"
+	}
+	if m.latestCommandResponse == "" {
+		m.textInput.Placeholder = "Follow up, ENTER or CTRL+C to quit"
+	}
 }
 
 func (m model) formatResponse(response string, isCode bool) (string, error) {
@@ -108,8 +429,7 @@ func (m model) formatResponse(response string, isCode bool) (string, error) {
 	// format nicely
 	formatted, err := m.markdownRenderer.Render(response)
 	if err != nil {
-		// TODO: handle error
-		panic(err)
+		return "", err
 	}
 
 	// trim preceding and trailing newlines
@@ -123,30 +443,120 @@ func (m model) formatResponse(response string, isCode bool) (string, error) {
 	return formatted, nil
 }
 
-// TODO: parse the model endpoint to infer whether it's openai, other, or local.
-// for local, suggest it may not be running, and how to run it
+// formatOrRaw formats a response, falling back to the raw text when it can't
+// be rendered.
+func (m model) formatOrRaw(response string, isCode bool) string {
+	formatted, err := m.formatResponse(response, isCode)
+	if err != nil {
+		return response
+	}
+	return formatted
+}
+
+// getErrorHint suggests a fix for the common classes of query errors.
+func (m model) getErrorHint(err error) (hint string, link string) {
+	var budgetErr *usage.BudgetError
+	if errors.As(err, &budgetErr) {
+		return "Raise the budget in ~/.shell-ai/config.yaml, or give the model a cheaper fallback.", ""
+	}
+	service := m.client.Describe()
+	switch llm.ClassifyError(err) {
+	case llm.ErrorAuth:
+		return "The API key was rejected. Check the key in the model's auth_env_var.", ""
+	case llm.ErrorQuota:
+		if service == "OpenAI" {
+			return "You've run out of quota, or need to set up billing. You can do so here:",
+				"https://platform.openai.com/account/billing"
+		}
+		return "You've run out of credit or quota with " + service + ".", ""
+	case llm.ErrorRateLimit:
+		return "You've hit a rate limit with " + service + ". Wait a moment and try again, or set a fallback model.", ""
+	case llm.ErrorModelMissing:
+		if strings.HasPrefix(service, "Ollama") {
+			return fmt.Sprintf("The model isn't installed. Run `ollama pull %s`, or pick one in `q config`.", m.client.ModelName()), ""
+		}
+		return "The model (or deployment) wasn't found. Check its name in ~/.shell-ai/config.yaml.", ""
+	case llm.ErrorContextOverflow:
+		return "The conversation is too long for this model. Start a new q session.", ""
+	case llm.ErrorUnreachable:
+		if strings.HasPrefix(service, "Ollama") {
+			return "Ollama doesn't seem to be running. Start it with `ollama serve`.", ""
+		}
+		return "Check your network connection, and that the endpoint in ~/.shell-ai/config.yaml is right.", ""
+	}
+	return "", ""
+}
+
 func (m model) getConnectionError(err error) string {
 	styleRed := lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
 	styleGreen := lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
 	styleDim := lipgloss.NewStyle().Faint(true).Width(m.maxWidth).PaddingLeft(2)
+	title := "Error: Failed to connect to " + m.client.Describe() + "."
+	var apiErr *llm.APIError
+	var budgetErr *usage.BudgetError
+	if errors.As(err, &apiErr) {
+		title = "Error: " + m.client.Describe() + " returned an error."
+	} else if errors.As(err, &budgetErr) {
+		title = "Error: Budget reached, the query was not sent."
+	}
 	message := fmt.Sprintf("\n  %v\n\n%v\n",
-		styleRed.Render("Error: Failed to connect to OpenAI."),
+		styleRed.Render(title),
 		styleDim.Render(err.Error()))
-	if util.IsLikelyBillingError(err.Error()) {
-		message = fmt.Sprintf("%v\n  %v %v\n\n  %v%v\n\n",
+	hint, link := m.getErrorHint(err)
+	if hint != "" {
+		message = fmt.Sprintf("%v\n  %v %v\n",
 			message,
 			styleGreen.Render("Hint:"),
-			"You may need to set up billing. You can do so here:",
+			hint,
+		)
+	}
+	if link != "" {
+		message = fmt.Sprintf("%v\n  %v%v\n",
+			message,
 			styleGreen.Render("->"),
-			styleDim.Render("https://platform.openai.com/account/billing"),
+			styleDim.Render(link),
 		)
 	}
-	return message
+	return message + "\n"
+}
+
+func (m model) getRiskBanner(report risk.Report) string {
+	if report.Level < risk.Medium {
+		return ""
+	}
+	style := lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+	if report.Level >= risk.High {
+		style = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	}
+	styleDim := lipgloss.NewStyle().Faint(true).Width(m.maxWidth).PaddingLeft(4)
+	var lines []string
+	for _, f := range report.Findings {
+		if f.Level < risk.Medium {
+			continue
+		}
+		lines = append(lines, styleDim.Render(fmt.Sprintf("- %s (%s)", f.Reason, f.Category)))
+	}
+	level := report.Level.String()
+	title := fmt.Sprintf("Warning: %s risk command.", strings.ToUpper(level[:1])+level[1:])
+	return fmt.Sprintf("\n\n  %s\n%s\n", style.Render(title), strings.Join(lines, "\n"))
 }
 
 func (m model) handleResponseMsg(msg responseMsg) (tea.Model, tea.Cmd) {
 	m.formattedPartialResponse = ""
 
+	m.cancelling = false
+
+	// cancelled by the user, keep what arrived so far
+	if errors.Is(msg.err, context.Canceled) {
+		m.state = RecevingInput
+		styleDim := lipgloss.NewStyle().Faint(true)
+		message := styleDim.Render("Cancelled.")
+		if msg.response != "" {
+			message = m.formatOrRaw(msg.response, util.StartsWithCodeBlock(msg.response)) + "\n" + message
+		}
+		return m, tea.Sequence(tea.Printf("%s", message), textinput.Blink)
+	}
+
 	// error handling
 	if msg.err != nil {
 		m.state = RecevingInput
@@ -154,10 +564,14 @@ func (m model) handleResponseMsg(msg responseMsg) (tea.Model, tea.Cmd) {
 		return m, tea.Sequence(tea.Printf("%s", message), textinput.Blink)
 	}
 
-	// parse out the code block
+	// parse out the code block, explanations have no command to take
 	content, isOnlyCode := util.ExtractFirstCodeBlock(msg.response)
+	if m.mode == config.ExplainMode {
+		content, isOnlyCode = "", false
+	}
 	if content != "" {
 		m.latestCommandResponse = content
+		m.latestRisk = risk.Analyze(content)
 	}
 
 	formatted, err := m.formatResponse(msg.response, util.StartsWithCodeBlock(msg.response))
@@ -166,20 +580,72 @@ func (m model) handleResponseMsg(msg responseMsg) (tea.Model, tea.Cmd) {
 		panic(err)
 	}
 
-	m.textInput.Placeholder = "Follow up, ENTER to copy & quit, CTRL+C to quit"
-	if !isOnlyCode {
-		m.textInput.Placeholder = "Follow up, ENTER to copy (code only), CTRL+C to quit"
-	}
-	if m.latestCommandResponse == "" {
-		m.textInput.Placeholder = "Follow up, ENTER or CTRL+C to quit"
-	}
-
 	m.state = RecevingInput
 	m.latestCommandIsCode = isOnlyCode
+	m.setFollowUpPlaceholder()
 	message := formatted
+	if m.fixing != "" && content != "" && content != m.fixing {
+		message += "\n" + diffCommands(m.fixing, content) + "\n"
+	}
+	if content != "" {
+		message += m.getRiskBanner(m.latestRisk)
+	}
+	if m.mode == config.ExplainMode {
+		message += m.getRiskBanner(m.explainedRisk)
+		m.explainedRisk = risk.Report{}
+	}
+	if msg.model != m.client.ModelName() {
+		styleDim := lipgloss.NewStyle().Faint(true)
+		message += "\n" + styleDim.Render(fmt.Sprintf("Answered by %s (fallback).", msg.model))
+	}
+	for _, notice := range trimmingNotices(msg.trimmed) {
+		styleDim := lipgloss.NewStyle().Faint(true)
+		message += "\n" + styleDim.Render(notice)
+	}
+	if msg.usage != nil {
+		message += "\n" + m.getUsageLine(*msg.usage)
+	}
+	styleYellow := lipgloss.NewStyle().Foreground(lipgloss.Color("3"))
+	for _, warning := range msg.budgetWarnings {
+		message += "\n  " + styleYellow.Render("Budget: "+warning)
+	}
 	return m, tea.Sequence(tea.Printf("%s", message), textinput.Blink)
 }
 
+// getUsageLine shows the tokens spent on an answer, and the session total
+// once there has been more than one.
+func (m *model) getUsageLine(record usage.Record) string {
+	m.sessionUsage.Add(record)
+	var answer usage.Totals
+	answer.Add(record)
+	line := answer.String()
+	if m.sessionUsage.Requests > 1 {
+		line += fmt.Sprintf(" (session: %s)", m.sessionUsage)
+	}
+	return lipgloss.NewStyle().Faint(true).Render("  " + line)
+}
+
+// getResumeMessage shows where a resumed session left off.
+func (m model) getResumeMessage() string {
+	styleDim := lipgloss.NewStyle().Faint(true)
+	turn, _ := m.session.LastTurn()
+	header := fmt.Sprintf("Resuming session from %s in %s (%d queries",
+		m.session.Updated.Local().Format("Jan 2 15:04"), m.session.Cwd, len(m.session.Turns))
+	if m.mode != "" {
+		header += ", " + m.mode + " mode"
+	}
+	header += ")."
+	query := lipgloss.NewStyle().Faint(true).Width(m.maxWidth).Render("> " + turn.Query)
+	formatted := m.formatOrRaw(turn.Response, util.StartsWithCodeBlock(turn.Response))
+	return styleDim.Render(header) + "\n\n" + query + "\n" + formatted
+}
+
+func (m model) handleRetryMsg(msg retryMsg) (tea.Model, tea.Cmd) {
+	m.retryReason = msg.reason
+	m.retryAt = time.Now().Add(msg.wait)
+	return m, nil
+}
+
 func (m model) handlePartialResponseMsg(msg partialResponseMsg) (tea.Model, tea.Cmd) {
 	m.state = ReceivingResponse
 	isCode := util.StartsWithCodeBlock(msg.content)
@@ -195,10 +661,28 @@ func (m model) handlePartialResponseMsg(msg partialResponseMsg) (tea.Model, tea.
 // === Init, Update, View === //
 
 func (m model) Init() tea.Cmd {
+	var cmds []tea.Cmd
+	for _, notice := range m.notices {
+		styleDim := lipgloss.NewStyle().Faint(true)
+		cmds = append(cmds, tea.Printf("%s", styleDim.Render(notice)))
+	}
+	if m.resumed {
+		cmds = append(cmds, tea.Printf("%s", m.getResumeMessage()))
+	}
+	if m.fixing != "" {
+		styleDim := lipgloss.NewStyle().Faint(true).Width(m.maxWidth)
+		cmds = append(cmds, tea.Printf("%s", styleDim.Render("> fix "+m.fixing)))
+	}
+	if m.mode == config.ExplainMode && m.runWithArgs {
+		styleDim := lipgloss.NewStyle().Faint(true).Width(m.maxWidth)
+		cmds = append(cmds, tea.Printf("%s", styleDim.Render("> explain "+m.query)))
+	}
 	if m.runWithArgs {
-		return tea.Batch(m.spinner.Tick, makeQuery(m.client, m.query))
+		cmds = append(cmds, tea.Batch(m.spinner.Tick, m.initialQuery))
+	} else {
+		cmds = append(cmds, textinput.Blink)
 	}
-	return textinput.Blink
+	return tea.Sequence(cmds...)
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -206,12 +690,27 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if (m.state == ConfirmingExecution || m.state == ConfirmingCopy) && msg.Type != tea.KeyCtrlC {
+			return m.handleConfirmKey(msg)
+		}
+		if m.state == EditingCommand && msg.Type != tea.KeyCtrlC {
+			return m.handleEditingKey(msg)
+		}
 		switch msg.Type {
-		case tea.KeyCtrlC, tea.KeyEsc, tea.KeyCtrlD:
+		case tea.KeyCtrlC:
+			return m.handleKeyCancel()
+
+		case tea.KeyEsc, tea.KeyCtrlD:
 			return m, tea.Quit
 
 		case tea.KeyEnter:
 			return m.handleKeyEnter()
+
+		case tea.KeyCtrlR:
+			return m.handleKeyRun()
+
+		case tea.KeyCtrlO:
+			return m.handleKeyEdit()
 		}
 
 	case responseMsg:
@@ -220,6 +719,12 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case partialResponseMsg:
 		return m.handlePartialResponseMsg(msg)
 
+	case retryMsg:
+		return m.handleRetryMsg(msg)
+
+	case commandFinishedMsg:
+		return m.handleCommandFinishedMsg(msg)
+
 	case setPMsg:
 		m.p = msg.p
 		return m, nil
@@ -243,24 +748,57 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 func (m model) View() string {
 	switch m.state {
 	case Loading:
+		if m.retryReason != "" {
+			styleDim := lipgloss.NewStyle().Faint(true)
+			wait := time.Until(m.retryAt).Round(time.Second)
+			if wait < 0 {
+				wait = 0
+			}
+			return m.spinner.View() + styleDim.Render(fmt.Sprintf("%s, retrying in %s", m.retryReason, wait))
+		}
 		return m.spinner.View()
 	case RecevingInput:
 		return m.textInput.View()
 	case ReceivingResponse:
 		return m.formattedPartialResponse + "\n"
+	case ConfirmingExecution:
+		styleDim := lipgloss.NewStyle().Faint(true)
+		styleCmd := lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
+		question := "in " + util.ShellName() + "? (y/N)"
+		if m.confirmationsLeft == 1 && m.latestRisk.Level >= risk.High {
+			question = "in " + util.ShellName() + "? This is a high risk command, are you sure? (y/N)"
+		}
+		return fmt.Sprintf("%s %s\n%s",
+			styleDim.Render("Run"),
+			styleCmd.Render(m.latestCommandResponse),
+			styleDim.Render(question))
+	case ConfirmingCopy:
+		styleRed := lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+		if m.widget {
+			return styleRed.Render("This is a high risk command. Insert it anyway? (y/N)")
+		}
+		return styleRed.Render("This is a high risk command. Copy it anyway? (y/N)")
+	case EditingCommand:
+		styleDim := lipgloss.NewStyle().Faint(true)
+		return m.textArea.View() + "\n" + styleDim.Render("CTRL+S to save, ESC to cancel")
 	}
 	return ""
 }
 
 // === Initial Model Setup === //
 
-func initialModel(prompt string, client *llm.LLMClient) model {
+func initialModel(prompt string, client *llm.LLMClient, ledger *usage.Ledger, session *history.Session) model {
 	maxWidth := util.GetTermSafeMaxWidth()
 	ti := textinput.New()
 	ti.Placeholder = "Describe a shell command, or ask a question."
 	ti.Focus()
 	ti.Width = maxWidth
 
+	ta := textarea.New()
+	ta.ShowLineNumbers = false
+	ta.Prompt = "$ "
+	ta.SetWidth(maxWidth)
+
 	s := spinner.New()
 	s.Spinner = spinner.Dot
 	s.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
@@ -273,8 +811,11 @@ func initialModel(prompt string, client *llm.LLMClient) model {
 	)
 	model := model{
 		client:                client,
+		ledger:                ledger,
+		session:               session,
 		markdownRenderer:      r,
 		textInput:             ti,
+		textArea:              ta,
 		spinner:               s,
 		state:                 RecevingInput,
 		query:                 "",
@@ -285,10 +826,20 @@ func initialModel(prompt string, client *llm.LLMClient) model {
 		err:                   nil,
 	}
 
+	if turn, ok := session.LastTurn(); ok {
+		model.resumed = true
+		if turn.Command != "" {
+			model.latestCommandResponse = turn.Command
+			model.latestCommandIsCode = true
+			model.latestRisk = risk.Analyze(turn.Command)
+			model.setFollowUpPlaceholder()
+		}
+	}
 	if runWithArgs {
 		model.runWithArgs = true
 		model.state = Loading
 		model.query = prompt
+		model.initialQuery = model.newQuery(prompt)
 	}
 	return model
 }
@@ -336,33 +887,205 @@ func streamHandler(p *tea.Program) func(content string, err error) {
 	}
 }
 
-func getModelConfig(appConfig config.AppConfig) (ModelConfig, error) {
+func retryHandler(p *tea.Program) func(reason string, wait time.Duration) {
+	return func(reason string, wait time.Duration) {
+		p.Send(retryMsg{reason, wait})
+	}
+}
+
+const defaultMaxStdinBytes = 32 * 1024
+
+// readStdin reads piped input, keeping at most limit bytes.
+func readStdin(limit int) (content string, truncated bool, err error) {
+	if limit <= 0 {
+		limit = defaultMaxStdinBytes
+	}
+	data, err := io.ReadAll(io.LimitReader(os.Stdin, int64(limit)+1))
+	if err != nil {
+		return "", false, err
+	}
+	if len(data) > limit {
+		return string(data[:limit]), true, nil
+	}
+	return string(data), false, nil
+}
+
+// attachContext adds the output of the context providers to the
+// conversation, and returns a notice saying what was included.
+func attachContext(client *llm.LLMClient, results []environment.Result) string {
+	var included, failed []string
+	for _, result := range results {
+		if result.Err != nil {
+			failed = append(failed, result.Summary())
+			continue
+		}
+		content := result.Content
+		if result.Truncated {
+			content += fmt.Sprintf("\n[truncated: only the first %d bytes were included]", len(result.Content))
+		}
+		// a resumed session may hold it already
+		if client.AddContext(result.Name, content) {
+			included = append(included, result.Summary())
+		}
+	}
+	var notices []string
+	if len(included) > 0 {
+		notices = append(notices, "Included context: "+strings.Join(included, ", ")+".")
+	}
+	if len(failed) > 0 {
+		notices = append(notices, "Failed to get context: "+strings.Join(failed, ", ")+".")
+	}
+	return strings.Join(notices, " ")
+}
+
+// getMode looks up the named mode. No name (or "default") is the model's own
+// prompt, returned as a mode without a name.
+func getMode(appConfig config.AppConfig, name string) (Mode, error) {
+	if name == "" || name == config.DefaultMode {
+		return Mode{}, nil
+	}
+	mode, ok := appConfig.FindMode(name)
+	if !ok {
+		return Mode{}, fmt.Errorf("unknown mode %q, available modes are: %s",
+			name, strings.Join(appConfig.ModeNames(), ", "))
+	}
+	return mode, nil
+}
+
+// getModelConfig picks the model for this run: the --model flag wins, then
+// $Q_MODEL, then the configured default.
+func getModelConfig(appConfig config.AppConfig, override string) (ModelConfig, error) {
 	if len(appConfig.Models) == 0 {
 		return ModelConfig{}, fmt.Errorf("no models available")
 	}
-	for _, model := range appConfig.Models {
-		if model.ModelName == appConfig.Preferences.DefaultModel {
-			return model, nil
+	name := appConfig.Preferences.DefaultModel
+	if env := os.Getenv("Q_MODEL"); env != "" {
+		name = env
+	}
+	if override != "" {
+		name = override
+	}
+	if name == "" {
+		return appConfig.Models[0], nil
+	}
+	model, ok := appConfig.FindModel(name)
+	if !ok {
+		return ModelConfig{}, fmt.Errorf("unknown model %q, configured models are: %s",
+			name, strings.Join(appConfig.ModelNames(), ", "))
+	}
+	return model, nil
+}
+
+// resolveAuth swaps the env var names in the config for their values. It
+// reports false if the model needs a key that isn't set.
+func resolveAuth(modelConfig ModelConfig) (ModelConfig, bool) {
+	auth := os.Getenv(modelConfig.Auth)
+	// local models (like Ollama) don't need a key
+	if modelConfig.Auth != "" && auth == "" {
+		return modelConfig, false
+	}
+	modelConfig.Auth = auth
+	modelConfig.OrgID = os.Getenv(modelConfig.OrgID)
+	return modelConfig, true
+}
+
+// getFallbackConfigs returns the models to try when the primary one fails:
+// its own fallback list, or the global one from preferences. Models that are
+// unknown or missing their key are skipped.
+func getFallbackConfigs(appConfig config.AppConfig, primary ModelConfig) []ModelConfig {
+	names := primary.Fallback
+	if len(names) == 0 {
+		names = appConfig.Preferences.Fallback
+	}
+	seen := map[string]bool{primary.ModelName: true}
+	var fallbacks []ModelConfig
+	for _, name := range names {
+		modelConfig, ok := appConfig.FindModel(name)
+		if !ok || seen[modelConfig.ModelName] {
+			continue
+		}
+		seen[modelConfig.ModelName] = true
+		if modelConfig, ok = resolveAuth(modelConfig); ok {
+			fallbacks = append(fallbacks, modelConfig)
 		}
 	}
-	// If the preferred model is not found, return the first model
-	return appConfig.Models[0], nil
+	return fallbacks
+}
+
+// runOptions changes how runQProgram starts.
+type runOptions struct {
+	// session is continued instead of starting a new one.
+	session *history.Session
+	// fixing is the failed command being corrected by q fix, which answers
+	// are diffed against.
+	fixing string
+	// mode is used over --mode and the continued session's mode, for
+	// commands that need their own, like q explain.
+	mode string
 }
 
-func runQProgram(prompt string) {
+// runQProgram answers the prompt in the TUI, or prints the answer when not
+// interactive.
+func runQProgram(prompt string, opts runOptions) {
+	session := opts.session
+	interactive := !printFlag && util.IsTerminal(os.Stdout)
+
 	appConfig, err := config.LoadAppConfig()
 	if err != nil {
+		if !interactive {
+			exitWithError(exitConfig, fmt.Errorf("failed to load config file: %w", err))
+		}
 		config.PrintConfigErrorMessage(err)
 		os.Exit(1)
 	}
 
-	modelConfig, err := getModelConfig(appConfig)
+	override := modelFlag
+	if session != nil && override == "" && os.Getenv("Q_MODEL") == "" {
+		// stay on the session's model, if it's still configured
+		if _, ok := appConfig.FindModel(session.Model); ok {
+			override = session.Model
+		}
+	}
+	modelConfig, err := getModelConfig(appConfig, override)
 	if err != nil {
-		config.PrintConfigErrorMessage(err)
+		if !interactive {
+			exitWithError(exitConfig, err)
+		}
+		styleRed := lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+		fmt.Printf("\n  %v\n\n", styleRed.Render("Error: "+err.Error()))
 		os.Exit(1)
 	}
-	auth := os.Getenv(modelConfig.Auth)
-	if auth == "" || os.Getenv(modelConfig.Auth) == "" {
+	modeName := opts.mode
+	if modeName == "" {
+		modeName = modeFlag
+	}
+	if modeName == "" && session != nil {
+		modeName = session.Mode
+	}
+	mode, err := getMode(appConfig, modeName)
+	if err != nil {
+		if !interactive {
+			exitWithError(exitConfig, err)
+		}
+		styleRed := lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+		fmt.Printf("\n  %v\n\n", styleRed.Render("Error: "+err.Error()))
+		os.Exit(1)
+	}
+	opts.mode = mode.Name
+	providers, err := environment.SelectProviders(appConfig.Contexts, contextFlag)
+	if err != nil {
+		if !interactive {
+			exitWithError(exitConfig, err)
+		}
+		styleRed := lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+		fmt.Printf("\n  %v\n\n", styleRed.Render("Error: "+err.Error()))
+		os.Exit(1)
+	}
+	modelConfig, ok := resolveAuth(modelConfig)
+	if !ok {
+		if !interactive {
+			exitWithError(exitConfig, fmt.Errorf("%s environment variable not set", modelConfig.Auth))
+		}
 		printAPIKeyNotSetMessage(modelConfig)
 		os.Exit(1)
 	}
@@ -370,30 +1093,159 @@ func runQProgram(prompt string) {
 	// TODO: maybe add a validating function
 	config.SaveAppConfig(appConfig)
 
-	orgID := os.Getenv(modelConfig.OrgID)
-	modelConfig.Auth = auth
-	modelConfig.OrgID = orgID
+	ledger, err := usage.OpenLedger(appConfig.Preferences.Budget)
+	if err != nil {
+		exitWithError(exitConfig, err)
+	}
+
+	c := llm.NewLLMClient(modelConfig, getFallbackConfigs(appConfig, modelConfig)...)
+	c.BeforeQuery = ledger.Check
+	if session == nil {
+		session = history.New(modelConfig.ModelName)
+		opts.session = session
+	}
+	session.Mode = mode.Name
+	if mode.Name != "" {
+		c.SetPrompt(mode.Prompt)
+	}
+	c.SetMessages(session.Messages)
+	if description := environment.Collect(appConfig.Preferences.Environment).Describe(); description != "" {
+		c.AddSystemContext(description)
+	}
 
-	c := llm.NewLLMClient(modelConfig)
-	p := tea.NewProgram(initialModel(prompt, c))
+	var notices []string
+	if notice := ledger.Unpriced(modelConfig); notice != "" {
+		notices = append(notices, notice)
+	}
+	if notice := attachContext(c, environment.RunProviders(providers)); notice != "" {
+		notices = append(notices, notice)
+	}
+	// widget mode is started with stdin on the terminal, never piped
+	stdinPiped := widgetOutput == nil && !util.IsTerminal(os.Stdin)
+	if stdinPiped {
+		content, truncated, err := readStdin(appConfig.Preferences.MaxStdinBytes)
+		if err != nil {
+			exitWithError(exitRequestFailed, fmt.Errorf("failed to read stdin: %w", err))
+		}
+		if truncated {
+			notices = append(notices, fmt.Sprintf("Piped input truncated to %d bytes (preferences.max_stdin_bytes).", len(content)))
+			content += fmt.Sprintf("\n[truncated: only the first %d bytes were included]", len(content))
+		}
+		if strings.TrimSpace(content) != "" {
+			c.AddContext("stdin", content)
+		}
+	}
+
+	if !interactive {
+		for _, notice := range notices {
+			fmt.Fprintln(os.Stderr, "q: "+notice)
+		}
+		os.Exit(runPrintMode(c, ledger, prompt, rawFlag, opts))
+	}
+	m := initialModel(prompt, c, ledger, session)
+	m.appConfig = appConfig
+	m.notices = notices
+	m.fixing = opts.fixing
+	m.mode = opts.mode
+	m.widget = widgetOutput != nil
+	if m.latestCommandResponse != "" || m.resumed {
+		m.setFollowUpPlaceholder()
+	}
+	if m.mode == config.ExplainMode && prompt != "" {
+		m.explainedRisk = risk.Analyze(prompt)
+	}
+	var programOpts []tea.ProgramOption
+	if stdinPiped || m.widget {
+		// stdin is used up, so take keyboard input from the terminal instead
+		programOpts = append(programOpts, tea.WithInputTTY())
+	}
+	p := tea.NewProgram(m, programOpts...)
 	c.StreamCallback = streamHandler(p)
-	if _, err := p.Run(); err != nil {
+	c.RetryCallback = retryHandler(p)
+	final, err := p.Run()
+	if err != nil {
 		fmt.Printf("Alas, there's been an error: %v", err)
 		os.Exit(1)
 	}
+	if m, ok := final.(model); ok && widgetOutput != nil && m.accepted != "" {
+		fmt.Fprintln(widgetOutput, m.accepted)
+	}
 }
 
+var (
+	printFlag    bool
+	rawFlag      bool
+	modelFlag    string
+	continueFlag bool
+	modeFlag     string
+	widgetFlag   bool
+	contextFlag  []string
+)
+
 var RootCmd = &cobra.Command{
 	Use:   "q [request]",
 	Short: "A command line interface for natural language queries",
 	Run: func(cmd *cobra.Command, args []string) {
 		// join args into a single string separated by spaces
 		prompt := strings.Join((args), " ")
+		if widgetFlag {
+			// the query is whatever was on the command line, even "config"
+			if err := setupWidget(); err != nil {
+				exitWithError(exitUsage, err)
+			}
+			runQProgram(prompt, runOptions{})
+			return
+		}
 		if len(args) > 0 && args[0] == "config" {
 			config.RunConfigProgram(args)
 			return
 		}
-		runQProgram(prompt)
+		// "q usage of du" is a query, the report takes no arguments
+		if len(args) == 1 && args[0] == "usage" {
+			runUsageReport()
+			return
+		}
+		// "q fix the permissions on my key" is a query, the subcommand takes
+		// its command with flags
+		if len(args) > 0 && args[0] == "fix" && (len(args) == 1 || strings.HasPrefix(args[1], "-")) {
+			runFixCommand(args[1:])
+			return
+		}
+		if len(args) > 0 && args[0] == "explain" {
+			runExplainCommand(args[1:])
+			return
+		}
+		if len(args) > 0 && args[0] == "shell-init" {
+			runShellInit(args[1:])
+			return
+		}
+		// "q history of this repo" is a query, not a session number
+		if len(args) > 0 && args[0] == "history" && (len(args) == 1 || history.IsRef(args[1])) {
+			runHistoryCommand(args[1:])
+			return
+		}
+		var session *history.Session
+		if continueFlag {
+			latest, err := history.Latest()
+			if err != nil {
+				exitWithError(exitUsage, err)
+			}
+			session = latest
+		}
+		runQProgram(prompt, runOptions{session: session})
 
 	},
 }
+
+func init() {
+	// flags must come before the request, so requests can contain dashes
+	RootCmd.Flags().SetInterspersed(false)
+	RootCmd.Flags().BoolVarP(&printFlag, "print", "p", false, "print the answer to stdout instead of opening the TUI (default when stdout is not a terminal)")
+	RootCmd.Flags().StringVarP(&modelFlag, "model", "m", "", "model name or alias to use for this run (overrides $Q_MODEL and the default model)")
+	RootCmd.Flags().BoolVar(&rawFlag, "raw", false, "with --print, stream the full answer instead of only the extracted code")
+	RootCmd.Flags().BoolVarP(&continueFlag, "continue", "c", false, "continue the last session")
+	RootCmd.Flags().StringVar(&modeFlag, "mode", "", "prompt mode to answer in, from the config file or built in (like explain)")
+	RootCmd.Flags().StringSliceVar(&contextFlag, "context", nil, "context providers from the config file to attach to this query (comma separated or repeated)")
+	RootCmd.Flags().BoolVar(&widgetFlag, "widget", false, "draw the UI on /dev/tty and print the accepted command to stdout (used by shell-init)")
+	RootCmd.Flags().MarkHidden("widget")
+}
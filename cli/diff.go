@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// diffOp is one step of a diff: a token kept, removed or added.
+type diffOp struct {
+	kind  byte // ' ', '-' or '+'
+	token string
+}
+
+// diffTokens finds the shortest edit from a to b with a longest common
+// subsequence table. Commands are short, so the quadratic table is fine.
+func diffTokens(a, b []string) []diffOp {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// diffCommands shows how a corrected command differs from the original.
+// Single line commands are diffed word by word, with the changed words
+// highlighted, and longer ones line by line.
+func diffCommands(original, corrected string) string {
+	styleRemoved := lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	styleAdded := lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	styleDim := lipgloss.NewStyle().Faint(true)
+
+	originalLines := strings.Split(strings.TrimSpace(original), "\n")
+	correctedLines := strings.Split(strings.TrimSpace(corrected), "\n")
+	if len(originalLines) > 1 || len(correctedLines) > 1 {
+		var lines []string
+		for _, op := range diffTokens(originalLines, correctedLines) {
+			switch op.kind {
+			case '-':
+				lines = append(lines, styleRemoved.Render("- "+op.token))
+			case '+':
+				lines = append(lines, styleAdded.Render("+ "+op.token))
+			default:
+				lines = append(lines, styleDim.Render("  "+op.token))
+			}
+		}
+		return "  " + strings.Join(lines, "\n  ")
+	}
+
+	var removed, added []string
+	for _, op := range diffTokens(strings.Fields(original), strings.Fields(corrected)) {
+		switch op.kind {
+		case '-':
+			removed = append(removed, styleRemoved.Underline(true).Render(op.token))
+		case '+':
+			added = append(added, styleAdded.Underline(true).Render(op.token))
+		default:
+			removed = append(removed, styleRemoved.Render(op.token))
+			added = append(added, styleAdded.Render(op.token))
+		}
+	}
+	return "  " + styleRemoved.Render("-") + " " + strings.Join(removed, " ") +
+		"\n  " + styleAdded.Render("+") + " " + strings.Join(added, " ")
+}
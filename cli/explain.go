@@ -0,0 +1,20 @@
+package cli
+
+import (
+	"fmt"
+	"q/config"
+	"strings"
+)
+
+// runExplainCommand explains a command, or the last command (recorded by the
+// shell-init hook) when none is given, in the built-in explain mode.
+func runExplainCommand(args []string) {
+	command := strings.TrimSpace(strings.Join(args, " "))
+	if command == "" {
+		command = strings.TrimSpace(lastCommand)
+	}
+	if command == "" {
+		exitWithError(exitUsage, fmt.Errorf("usage: q explain <command>"))
+	}
+	runQProgram(command, runOptions{mode: config.ExplainMode})
+}
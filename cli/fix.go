@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"q/util"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// runFixCommand asks for a corrected version of the last command. The
+// command and its exit code come from the shell hook installed by
+// shell-init, or from --cmd and --status. Its output can be piped in or
+// passed with --stderr. Any other arguments are added to the query.
+func runFixCommand(args []string) {
+	flags := pflag.NewFlagSet("fix", pflag.ContinueOnError)
+	command := flags.String("cmd", "", "the command to fix (default: the last command, recorded by the shell-init hook)")
+	output := flags.String("stderr", "", "the error output of the command (default: piped input)")
+	status := flags.Int("status", 0, "the exit code of the command (default: the last exit code, recorded by the shell-init hook)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: q fix [flags] [extra instructions]\n\n%s", flags.FlagUsages())
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			os.Exit(exitOK)
+		}
+		exitWithError(exitUsage, err)
+	}
+
+	if *command == "" {
+		*command = lastCommand
+		if !flags.Changed("status") {
+			if s, err := strconv.Atoi(lastStatus); err == nil {
+				*status = s
+			}
+		}
+	}
+	*command = strings.TrimSpace(*command)
+	if *command == "" {
+		exitWithError(exitUsage, fmt.Errorf("no command to fix, pass one with --cmd or record them with `q shell-init`"))
+	}
+	// piped output is part of the query here, so it isn't attached again as
+	// separate context
+	if *output == "" && !util.IsTerminal(os.Stdin) {
+		content, _, err := readStdin(defaultMaxStdinBytes)
+		if err != nil {
+			exitWithError(exitRequestFailed, fmt.Errorf("failed to read stdin: %w", err))
+		}
+		*output = content
+	}
+
+	query := fixQuery(*command, *status, *output, strings.Join(flags.Args(), " "))
+	runQProgram(query, runOptions{fixing: *command})
+}
+
+func fixQuery(command string, status int, output, instructions string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "This command didn't work:\n```\n%s\n```\n", command)
+	if status > 0 {
+		fmt.Fprintf(&b, "It exited with code %d.\n", status)
+	}
+	if output = strings.TrimSpace(output); output != "" {
+		fmt.Fprintf(&b, "It printed:\n```\n%s\n```\n", output)
+	}
+	if instructions != "" {
+		fmt.Fprintf(&b, "%s\n", instructions)
+	}
+	b.WriteString("Reply with the corrected command in a code block, then say in one short sentence what was wrong.")
+	return b.String()
+}
@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"q/history"
+	"q/util"
+	"strconv"
+	"strings"
+
+	"github.com/atotto/clipboard"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const historyListLength = 20
+
+// runHistoryCommand opens the history browser (or lists the saved sessions
+// when not in a terminal), or reopens a session given its number in the list
+// or its ID. Any further arguments are sent as a follow up in the reopened
+// session.
+func runHistoryCommand(args []string) {
+	sessions, err := history.List()
+	if err != nil {
+		exitWithError(exitConfig, err)
+	}
+	if len(args) == 0 {
+		if len(sessions) == 0 || !util.IsTerminal(os.Stdin) || !util.IsTerminal(os.Stdout) {
+			printHistory(sessions)
+			return
+		}
+		action, err := history.Browse(sessions)
+		if err != nil {
+			exitWithError(exitRequestFailed, err)
+		}
+		runHistoryAction(action)
+		return
+	}
+	session := findSession(sessions, args[0])
+	if session == nil {
+		exitWithError(exitUsage, fmt.Errorf("no session %q, run `q history` to list them", args[0]))
+	}
+	runQProgram(strings.Join(args[1:], " "), runOptions{session: session})
+}
+
+// runHistoryAction carries out what was chosen in the history browser.
+func runHistoryAction(action history.Action) {
+	styleDim := lipgloss.NewStyle().Faint(true)
+	switch action.Kind {
+	case history.ActionCopy:
+		if action.Turn.Command == "" {
+			fmt.Printf("\n  %s\n\n", styleDim.Render("That answer has no command to copy."))
+			return
+		}
+		if err := clipboard.WriteAll(action.Turn.Command); err != nil {
+			exitWithError(exitRequestFailed, fmt.Errorf("failed to copy to the clipboard: %w", err))
+		}
+		fmt.Printf("\n  %s\n\n", styleDim.Render("Copied to clipboard: "+action.Turn.Command))
+	case history.ActionRerun:
+		runQProgram(action.Turn.Query, runOptions{mode: action.Session.Mode})
+	case history.ActionContinue:
+		runQProgram("", runOptions{session: action.Session})
+	}
+}
+
+func findSession(sessions []*history.Session, ref string) *history.Session {
+	if n, err := strconv.Atoi(ref); err == nil && n >= 1 && n <= len(sessions) {
+		return sessions[n-1]
+	}
+	for _, session := range sessions {
+		if session.ID == ref {
+			return session
+		}
+	}
+	return nil
+}
+
+func printHistory(sessions []*history.Session) {
+	if len(sessions) == 0 {
+		fmt.Print("\n  No saved sessions yet.\n\n")
+		return
+	}
+	styleDim := lipgloss.NewStyle().Faint(true)
+	var b strings.Builder
+	b.WriteString("\n")
+	for i, session := range sessions {
+		if i == historyListLength {
+			fmt.Fprintf(&b, "  %s\n", styleDim.Render(fmt.Sprintf("... and %d older sessions", len(sessions)-i)))
+			break
+		}
+		fmt.Fprintf(&b, "  %2d  %s  %s %s\n",
+			i+1,
+			styleDim.Render(session.Updated.Local().Format("Jan 02 15:04")),
+			util.Truncate(session.Title(), 60),
+			styleDim.Render(fmt.Sprintf("(%s, %s)", shortenHome(session.Cwd), queryCount(len(session.Turns)))),
+		)
+	}
+	fmt.Fprintf(&b, "\n  %s\n\n", styleDim.Render("Reopen one with `q history <number>`, or the last one with `q --continue`."))
+	fmt.Print(b.String())
+}
+
+func queryCount(n int) string {
+	if n == 1 {
+		return "1 query"
+	}
+	return fmt.Sprintf("%d queries", n)
+}
+
+// shortenHome replaces the home directory at the start of path with ~.
+func shortenHome(path string) string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return path
+	}
+	if path == home {
+		return "~"
+	}
+	if strings.HasPrefix(path, home+string(filepath.Separator)) {
+		return "~" + path[len(home):]
+	}
+	return path
+}
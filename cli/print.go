@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"q/config"
+	"q/llm"
+	"q/risk"
+	"q/usage"
+	"q/util"
+	"strings"
+	"time"
+)
+
+// Exit codes for non-interactive mode.
+const (
+	exitOK = iota
+	exitRequestFailed
+	exitUsage
+	exitConfig
+	exitBudget
+)
+
+func exitWithError(code int, err error) {
+	fmt.Fprintf(os.Stderr, "q: %v\n", err)
+	os.Exit(code)
+}
+
+// runPrintMode answers the query without the TUI. By default only the
+// extracted code block is written to stdout (or the whole answer if there is
+// none); with raw set the full answer is streamed as it arrives.
+func runPrintMode(client *llm.LLMClient, ledger *usage.Ledger, prompt string, raw bool, opts runOptions) int {
+	if prompt == "" {
+		fmt.Fprintln(os.Stderr, "q: no query given")
+		return exitUsage
+	}
+	// explanations have no command to extract, they're always printed whole
+	raw = raw || opts.mode == config.ExplainMode
+	printed := 0
+	client.StreamCallback = func(content string, err error) {
+		if !raw || len(content) <= printed {
+			return
+		}
+		fmt.Print(content[printed:])
+		printed = len(content)
+	}
+	client.RetryCallback = func(reason string, wait time.Duration) {
+		fmt.Fprintf(os.Stderr, "q: %s, retrying in %s\n", reason, wait.Round(time.Second))
+	}
+	response, err := client.Query(context.Background(), prompt)
+	if response != "" {
+		saveTurn(opts.session, client, prompt, response)
+	}
+	_, warnings := recordUsage(ledger, client)
+	if err != nil {
+		if printed > 0 {
+			fmt.Println()
+		}
+		var budgetErr *usage.BudgetError
+		if errors.As(err, &budgetErr) {
+			fmt.Fprintf(os.Stderr, "q: %v\n", err)
+			return exitBudget
+		}
+		fmt.Fprintf(os.Stderr, "q: %s: %v\n", client.Describe(), err)
+		return exitRequestFailed
+	}
+	if client.AnsweredBy() != client.ModelName() {
+		fmt.Fprintf(os.Stderr, "q: answered by %s (fallback)\n", client.AnsweredBy())
+	}
+	for _, notice := range trimmingNotices(client.Trimmed()) {
+		fmt.Fprintln(os.Stderr, "q: "+notice)
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "q: budget: %s\n", warning)
+	}
+	if raw {
+		fmt.Print(response[util.Clamp(printed, 0, len(response)):])
+		if !strings.HasSuffix(response, "\n") {
+			fmt.Println()
+		}
+		if opts.mode == config.ExplainMode {
+			printRiskFindings(prompt)
+		}
+		return exitOK
+	}
+	if code, _ := util.ExtractFirstCodeBlock(response); code != "" {
+		if opts.fixing != "" && code != opts.fixing {
+			fmt.Fprintln(os.Stderr, diffCommands(opts.fixing, code))
+		}
+		// scripts eval what's on stdout, so the warnings go to stderr first
+		printRiskFindings(code)
+		fmt.Println(code)
+		return exitOK
+	}
+	fmt.Println(strings.TrimSpace(response))
+	return exitOK
+}
+
+// printRiskFindings warns on stderr about the risky parts of a command.
+func printRiskFindings(command string) {
+	for _, f := range risk.Analyze(command).Findings {
+		if f.Level >= risk.Medium {
+			fmt.Fprintf(os.Stderr, "q: %s risk: %s (%s)\n", f.Level, f.Reason, f.Category)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"q/usage"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// runUsageReport prints today's and this month's totals from the usage
+// ledger, broken down by model.
+func runUsageReport() {
+	records, err := usage.Load()
+	if err != nil {
+		exitWithError(exitConfig, err)
+	}
+	if len(records) == 0 {
+		fmt.Print("\n  No usage recorded yet.\n\n")
+		return
+	}
+	now := time.Now()
+	var b strings.Builder
+	b.WriteString("\n")
+	writeUsagePeriod(&b, "Today", usage.Since(records, usage.StartOfDay(now)))
+	writeUsagePeriod(&b, now.Format("January 2006"), usage.Since(records, usage.StartOfMonth(now)))
+	fmt.Fprint(os.Stdout, b.String())
+}
+
+func writeUsagePeriod(b *strings.Builder, title string, summary usage.Summary) {
+	styleTitle := lipgloss.NewStyle().Bold(true)
+	styleDim := lipgloss.NewStyle().Faint(true)
+
+	fmt.Fprintf(b, "  %s\n", styleTitle.Render(title))
+	if summary.Total.Requests == 0 {
+		fmt.Fprintf(b, "  %s\n\n", styleDim.Render("Nothing yet."))
+		return
+	}
+	models := summary.Models()
+	width := 0
+	for _, model := range models {
+		if len(model) > width {
+			width = len(model)
+		}
+	}
+	for _, model := range models {
+		totals := summary.ByModel[model]
+		fmt.Fprintf(b, "  %-*s  %s %s\n", width, model, totals, styleDim.Render(requestCount(totals.Requests)))
+	}
+	if len(models) > 1 {
+		fmt.Fprintf(b, "  %-*s  %s %s\n", width, "total", summary.Total, styleDim.Render(requestCount(summary.Total.Requests)))
+	}
+	b.WriteString("\n")
+}
+
+func requestCount(n int) string {
+	if n == 1 {
+		return "(1 request)"
+	}
+	return fmt.Sprintf("(%d requests)", n)
+}
@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+// widgetOutput is where the accepted command goes in widget mode (the
+// original stdout, read by the shell), while the UI is drawn on /dev/tty.
+// It's nil outside widget mode.
+var widgetOutput *os.File
+
+// setupWidget points the UI at /dev/tty, keeping stdout free for the
+// accepted command.
+func setupWidget() error {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("widget mode needs a terminal: %w", err)
+	}
+	widgetOutput = os.Stdout
+	os.Stdout = tty
+	termenv.SetDefaultOutput(termenv.NewOutput(tty))
+	lipgloss.SetDefaultRenderer(lipgloss.NewRenderer(tty))
+	return nil
+}
+
+const zshInit = `# q shell integration for zsh, add to ~/.zshrc:
+#   eval "$(q shell-init zsh)"
+# Press Ctrl+G to turn the command line into a command, and run q fix to
+# correct the last command.
+_q_widget() {
+  local cmd
+  zle -I
+  cmd=$(q --widget -- "$BUFFER" </dev/tty)
+  if [[ -n $cmd ]]; then
+    BUFFER=$cmd
+    CURSOR=${#BUFFER}
+  fi
+  zle reset-prompt
+}
+zle -N _q_widget
+bindkey '^G' _q_widget
+
+# record the last command and its exit code for q fix, in shell variables
+# that only q gets to see (commands can hold secrets)
+_q_preexec() { _q_cmd=$1 }
+_q_precmd() {
+  local q_status=$?
+  if [[ -n $_q_cmd ]]; then
+    _q_last_command=$_q_cmd
+    _q_last_status=$q_status
+    _q_cmd=
+  fi
+}
+autoload -Uz add-zsh-hook
+add-zsh-hook preexec _q_preexec
+add-zsh-hook precmd _q_precmd
+q() { env Q_LAST_COMMAND="$_q_last_command" Q_LAST_STATUS="$_q_last_status" q "$@" }
+`
+
+const bashInit = `# q shell integration for bash, add to ~/.bashrc:
+#   eval "$(q shell-init bash)"
+# Press Ctrl+G to turn the command line into a command, and run q fix to
+# correct the last command.
+_q_widget() {
+  local cmd
+  cmd=$(q --widget -- "$READLINE_LINE" </dev/tty)
+  if [[ -n $cmd ]]; then
+    READLINE_LINE=$cmd
+    READLINE_POINT=${#READLINE_LINE}
+  fi
+}
+bind -x '"\C-g": _q_widget'
+
+# record the last command and its exit code for q fix, in shell variables
+# that only q gets to see (commands can hold secrets)
+_q_precmd() {
+  local q_status=$? q_cmd
+  read -r _ q_cmd <<<"$(HISTTIMEFORMAT= builtin history 1)"
+  _q_last_command=$q_cmd
+  _q_last_status=$q_status
+}
+if [[ $PROMPT_COMMAND != *_q_precmd* ]]; then
+  PROMPT_COMMAND="_q_precmd${PROMPT_COMMAND:+;$PROMPT_COMMAND}"
+fi
+q() { env Q_LAST_COMMAND="$_q_last_command" Q_LAST_STATUS="$_q_last_status" q "$@"; }
+`
+
+const fishInit = `# q shell integration for fish, add to ~/.config/fish/config.fish:
+#   q shell-init fish | source
+# Press Ctrl+G to turn the command line into a command, and run q fix to
+# correct the last command.
+function _q_widget
+    set -l cmd (q --widget -- (commandline) </dev/tty | string collect)
+    if test -n "$cmd"
+        commandline -r -- $cmd
+        commandline -f end-of-buffer
+    end
+    commandline -f repaint
+end
+bind \cg _q_widget
+
+# record the last command and its exit code for q fix, in shell variables
+# that only q gets to see (commands can hold secrets)
+function _q_postexec --on-event fish_postexec
+    set -g _q_last_status $status
+    set -g _q_last_command $argv[1]
+end
+function q --wraps q
+    env Q_LAST_COMMAND="$_q_last_command" Q_LAST_STATUS="$_q_last_status" q $argv
+end
+`
+
+var shellInitScripts = map[string]string{
+	"zsh":  zshInit,
+	"bash": bashInit,
+	"fish": fishInit,
+}
+
+// runShellInit prints the integration script for the given shell.
+func runShellInit(args []string) {
+	if len(args) != 1 {
+		exitWithError(exitUsage, fmt.Errorf("usage: q shell-init zsh|bash|fish"))
+	}
+	script, ok := shellInitScripts[args[0]]
+	if !ok {
+		exitWithError(exitUsage, fmt.Errorf("unsupported shell %q, expected zsh, bash or fish", args[0]))
+	}
+	fmt.Print(script)
+}
+
+// lastCommand and lastStatus are the last command and its exit code, passed
+// to q alone by the shell-init hook. They're taken out of the environment so
+// the commands q runs don't inherit them.
+var lastCommand, lastStatus = takeEnv("Q_LAST_COMMAND"), takeEnv("Q_LAST_STATUS")
+
+func takeEnv(key string) string {
+	value := os.Getenv(key)
+	os.Unsetenv(key)
+	return value
+}
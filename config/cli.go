@@ -11,6 +11,7 @@ import (
 	"strings"
 
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/glamour"
 	"github.com/charmbracelet/lipgloss"
@@ -179,6 +180,7 @@ type page int
 
 const (
 	ListPage page = iota
+	PullPage
 )
 
 type state struct {
@@ -197,6 +199,9 @@ type model struct {
 	backstack []state
 
 	appConfig AppConfig
+	pull      pullState
+	// listingOllama is set while the install menu waits for Ollama's models.
+	listingOllama bool
 
 	quitting bool
 }
@@ -216,6 +221,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, quit()
 		}
 	case backMsg:
+		m.listingOllama = false
 		if len(m.backstack) > 0 {
 			m.state = m.backstack[len(m.backstack)-1]
 			m.backstack = m.backstack[:len(m.backstack)-1]
@@ -223,6 +229,45 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.list.Select(m.state.listIndex)
 		}
 		return m, nil
+
+	case setPullPageMsg:
+		m.backstack = append(m.backstack, m.state)
+		m.state = state{page: PullPage, menu: m.state.menu}
+		m.pull = newPullState()
+		return m, textinput.Blink
+
+	case openInstallMenuMsg:
+		m.backstack = append(m.backstack, m.state)
+		m.state = state{page: ListPage, menu: listingOllamaMenu}
+		m.list = listingOllamaMenu(m.appConfig)
+		m.listingOllama = true
+		return m, listOllamaModels()
+
+	case ollamaModelsMsg:
+		menu := installModelMenu(msg)
+		switch {
+		case m.listingOllama:
+			m.listingOllama = false
+			m.state.menu = menu
+			m.list = menu(m.appConfig)
+		case m.state.page == PullPage && len(m.backstack) > 0:
+			// list the pulled model when going back
+			m.backstack[len(m.backstack)-1].menu = menu
+		}
+		return m, nil
+
+	case addModelMsg:
+		if _, ok := m.appConfig.FindModel(msg.model.ModelName); !ok {
+			m.appConfig.Models = append(m.appConfig.Models, msg.model)
+		}
+		index := m.list.Index()
+		m.list = m.state.menu(m.appConfig)
+		m.list.Select(index)
+		return m, saveConfig(m.appConfig)
+	}
+
+	if m.state.page == PullPage {
+		return m.updatePullPage(msg)
 	}
 
 	switch msg := msg.(type) {
@@ -285,6 +330,9 @@ func (m model) View() string {
 		return ""
 		// return quitTextStyle.Render("Changes saved to ~/.shell-ai/config.yaml")
 	}
+	if m.state.page == PullPage {
+		return m.pullPageView()
+	}
 	return "\n" + m.list.View()
 }
 
@@ -356,6 +404,7 @@ func defaultModelSelectMenu(appConfig AppConfig) list.Model {
 		model := model
 		modelItems = append(modelItems, menuItem{
 			title:     model.ModelName,
+			data:      strings.Join(model.Aliases, ", "),
 			selectCmd: tea.Sequence(setDefaultModel(model.ModelName), back()),
 		})
 	}
@@ -378,10 +427,10 @@ func configureModelsMenu(appConfig AppConfig) list.Model {
 	})
 	modelItems = append(modelItems, menuItem{
 		title:     "Install Model",
-		data:      "coming soon!",
-		selectCmd: openBrowser("https://github.com/ibigio/shell-ai#custom-model-configuration-new"),
+		data:      "ollama",
+		selectCmd: openInstallMenu(),
 	})
-	return defaultList("Configure Models (coming soon!)", modelItems)
+	return defaultList("Configure Models", modelItems)
 }
 
 func modelDetailsMenu(modelConfig types.ModelConfig) menuFunc {
@@ -395,6 +444,9 @@ func modelDetailsForModelMenu(appConfig AppConfig, modelConfig types.ModelConfig
 		{
 			title: "Name: " + modelConfig.ModelName,
 		},
+		{
+			title: "Provider: " + providerName(modelConfig),
+		},
 		{
 			title: "Endpoint: " + modelConfig.Endpoint,
 		},
@@ -411,6 +463,13 @@ func modelDetailsForModelMenu(appConfig AppConfig, modelConfig types.ModelConfig
 	return defaultList(modelConfig.ModelName+"(editing coming soon!)", items)
 }
 
+func providerName(modelConfig types.ModelConfig) string {
+	if modelConfig.Provider == "" {
+		return "openai"
+	}
+	return modelConfig.Provider
+}
+
 func PrintConfigErrorMessage(err error) {
 	maxWidth := util.GetTermSafeMaxWidth()
 	styleRed := lipgloss.NewStyle().Foreground(lipgloss.Color("9")).PaddingLeft(2)
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	. "q/types"
+	"strings"
 
 	_ "embed"
 
@@ -12,14 +13,43 @@ import (
 )
 
 type AppConfig struct {
-	Models      []ModelConfig `yaml:"models"`
-	Preferences Preferences   `yaml:"preferences"`
-	Version     string        `yaml:"config_format_version"`
+	Models      []ModelConfig     `yaml:"models"`
+	Preferences Preferences       `yaml:"preferences"`
+	Contexts    []ContextProvider `yaml:"contexts,omitempty"`
+	Modes       []Mode            `yaml:"modes,omitempty"`
+	Version     string            `yaml:"config_format_version"`
 }
 
 // //go:embed config.yaml
 // var embeddedConfigFile []byte
 
+// FindModel looks up a model by name or alias.
+func (c AppConfig) FindModel(name string) (ModelConfig, bool) {
+	for _, model := range c.Models {
+		if model.ModelName == name {
+			return model, true
+		}
+		for _, alias := range model.Aliases {
+			if alias == name {
+				return model, true
+			}
+		}
+	}
+	return ModelConfig{}, false
+}
+
+// ModelNames lists the configured models, with their aliases in parentheses.
+func (c AppConfig) ModelNames() []string {
+	names := make([]string, len(c.Models))
+	for i, model := range c.Models {
+		names[i] = model.ModelName
+		if len(model.Aliases) > 0 {
+			names[i] += " (" + strings.Join(model.Aliases, ", ") + ")"
+		}
+	}
+	return names
+}
+
 //go:embed config.yaml
 var embeddedConfigFile []byte
 var configFilePath string = ".shell-ai/config.yaml"
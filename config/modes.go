@@ -0,0 +1,60 @@
+package config
+
+import (
+	. "q/types"
+)
+
+// DefaultMode is the model's own prompt, for switching back from a mode.
+const DefaultMode = "default"
+
+// ExplainMode answers with a breakdown of the command in the query instead
+// of generating one. It's what q explain runs.
+const ExplainMode = "explain"
+
+// BuiltinModes are available without configuring them. A mode with the same
+// name in the config file takes their place.
+var BuiltinModes = []Mode{
+	{
+		Name:        ExplainMode,
+		Description: "break down a command and flag its risky parts",
+		Prompt: []Message{
+			{Role: "system", Content: `You explain shell commands. The user gives you a command, explain it part by part so they know what it does before running it.
+
+Reply in Markdown with:
+1. One sentence saying what the whole command does.
+2. A "### Breakdown" section with one bullet per part, in the order they appear: every program, flag, argument, pipe (|), redirection (>, >>, <, 2>&1, ...) and substitution, written as inline code followed by what it does. Explain combined short flags like -xzvf letter by letter. For pipelines, say what flows into each stage.
+3. A "### Risks" section listing anything that deletes or overwrites data, needs root, changes permissions, runs downloaded code, or is otherwise hard to undo, each starting with ⚠️. Write "None." if nothing is risky.
+
+Don't rewrite the command or suggest alternatives unless asked. Answer follow up questions about the command briefly.`},
+		},
+	},
+}
+
+// FindMode looks up a mode by name, in the config file and then the
+// built-in ones.
+func (c AppConfig) FindMode(name string) (Mode, bool) {
+	for _, mode := range c.allModes() {
+		if mode.Name == name {
+			return mode, true
+		}
+	}
+	return Mode{}, false
+}
+
+// ModeNames lists the modes that can be picked, the default one first.
+func (c AppConfig) ModeNames() []string {
+	names := []string{DefaultMode}
+	seen := map[string]bool{DefaultMode: true}
+	for _, mode := range c.allModes() {
+		if !seen[mode.Name] {
+			seen[mode.Name] = true
+			names = append(names, mode.Name)
+		}
+	}
+	return names
+}
+
+func (c AppConfig) allModes() []Mode {
+	modes := append([]Mode(nil), c.Modes...)
+	return append(modes, BuiltinModes...)
+}
@@ -0,0 +1,239 @@
+package config
+
+import (
+	"fmt"
+	"q/llm"
+	. "q/types"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type pullState struct {
+	input    textinput.Model
+	progress progress.Model
+	pulling  bool
+	status   string
+	err      error
+}
+
+type setPullPageMsg struct{}
+
+type addModelMsg struct {
+	model ModelConfig
+}
+
+type pullProgressMsg struct {
+	progress llm.OllamaPullProgress
+	updates  chan tea.Msg
+}
+
+type pullFinishedMsg struct {
+	name string
+	err  error
+}
+
+type openInstallMenuMsg struct{}
+
+type ollamaModelsMsg struct {
+	host   string
+	models []llm.OllamaModel
+	err    error
+}
+
+func setPullPage() tea.Cmd {
+	return func() tea.Msg { return setPullPageMsg{} }
+}
+
+func addModel(model ModelConfig) tea.Cmd {
+	return func() tea.Msg { return addModelMsg{model} }
+}
+
+func openInstallMenu() tea.Cmd {
+	return func() tea.Msg { return openInstallMenuMsg{} }
+}
+
+// listOllamaModels asks Ollama for its models off the UI loop, as it can take
+// a few seconds to time out when Ollama isn't running.
+func listOllamaModels() tea.Cmd {
+	return func() tea.Msg {
+		host := llm.OllamaHost()
+		models, err := llm.ListOllamaModels(host)
+		return ollamaModelsMsg{host, models, err}
+	}
+}
+
+// startPull runs the pull in the background and feeds its progress back
+// into the program one message at a time.
+func startPull(name string) tea.Cmd {
+	updates := make(chan tea.Msg)
+	go func() {
+		err := llm.PullOllamaModel(llm.OllamaHost(), name, func(p llm.OllamaPullProgress) {
+			updates <- pullProgressMsg{p, updates}
+		})
+		updates <- pullFinishedMsg{name, err}
+	}()
+	return waitForPull(updates)
+}
+
+func waitForPull(updates chan tea.Msg) tea.Cmd {
+	return func() tea.Msg { return <-updates }
+}
+
+func newPullState() pullState {
+	ti := textinput.New()
+	ti.Placeholder = "Model to pull, e.g. llama3.2 or qwen2.5-coder:7b"
+	ti.Width = 60
+	ti.Focus()
+	return pullState{
+		input:    ti,
+		progress: progress.New(progress.WithDefaultGradient(), progress.WithWidth(60)),
+	}
+}
+
+// newOllamaModelConfig sets up an installed Ollama model, borrowing the prompt
+// of the current default model.
+func newOllamaModelConfig(name string, appConfig AppConfig) ModelConfig {
+	var prompt []Message
+	if model, ok := appConfig.FindModel(appConfig.Preferences.DefaultModel); ok {
+		prompt = model.Prompt
+	} else if len(appConfig.Models) > 0 {
+		prompt = appConfig.Models[0].Prompt
+	}
+	return ModelConfig{
+		ModelName: name,
+		Provider:  llm.ProviderOllama,
+		Endpoint:  llm.OllamaChatEndpoint(llm.OllamaHost()),
+		Prompt:    prompt,
+	}
+}
+
+// installModelMenu lists the models Ollama has, to add them to the config.
+func installModelMenu(listing ollamaModelsMsg) menuFunc {
+	return func(appConfig AppConfig) list.Model {
+		if listing.err != nil {
+			items := []menuItem{
+				{
+					title:     "Ollama is not running",
+					data:      listing.host,
+					selectCmd: openBrowser("https://ollama.com/download"),
+				},
+			}
+			return defaultList("Install Model (Ollama)", items)
+		}
+		var items []menuItem
+		for _, model := range listing.models {
+			data := formatSize(model.Size)
+			if _, ok := appConfig.FindModel(model.Name); ok {
+				data = "added"
+			}
+			items = append(items, menuItem{
+				title:     model.Name,
+				data:      data,
+				selectCmd: addModel(newOllamaModelConfig(model.Name, appConfig)),
+			})
+		}
+		items = append(items, menuItem{
+			title:     "Pull a Model",
+			data:      "ollama.com/library",
+			selectCmd: setPullPage(),
+		})
+		return defaultList("Install Model (Ollama)", items)
+	}
+}
+
+// listingOllamaMenu stands in for the install menu until Ollama answers.
+func listingOllamaMenu(appConfig AppConfig) list.Model {
+	items := []menuItem{
+		{
+			title: "Looking for Ollama...",
+			data:  llm.OllamaHost(),
+		},
+	}
+	return defaultList("Install Model (Ollama)", items)
+}
+
+func (m model) updatePullPage(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyEsc:
+			if m.pull.pulling {
+				return m, nil
+			}
+			return m, back()
+		case tea.KeyEnter:
+			name := strings.TrimSpace(m.pull.input.Value())
+			if m.pull.pulling || name == "" {
+				return m, nil
+			}
+			m.pull.pulling = true
+			m.pull.err = nil
+			m.pull.status = "Starting pull..."
+			m.pull.input.Blur()
+			return m, startPull(name)
+		}
+		m.pull.input, cmd = m.pull.input.Update(msg)
+		return m, cmd
+
+	case pullProgressMsg:
+		m.pull.status = msg.progress.Status
+		var cmds []tea.Cmd
+		if msg.progress.Total > 0 {
+			percent := float64(msg.progress.Completed) / float64(msg.progress.Total)
+			cmds = append(cmds, m.pull.progress.SetPercent(percent))
+		}
+		cmds = append(cmds, waitForPull(msg.updates))
+		return m, tea.Batch(cmds...)
+
+	case pullFinishedMsg:
+		m.pull.pulling = false
+		if msg.err != nil {
+			m.pull.err = msg.err
+			m.pull.input.Focus()
+			return m, textinput.Blink
+		}
+		m.pull.status = fmt.Sprintf("Added %s to your models. Press ESC to go back.", msg.name)
+		if _, ok := m.appConfig.FindModel(msg.name); ok {
+			return m, tea.Batch(m.pull.progress.SetPercent(1), listOllamaModels())
+		}
+		m.appConfig.Models = append(m.appConfig.Models, newOllamaModelConfig(msg.name, m.appConfig))
+		return m, tea.Batch(m.pull.progress.SetPercent(1), saveConfig(m.appConfig), listOllamaModels())
+
+	case progress.FrameMsg:
+		progressModel, cmd := m.pull.progress.Update(msg)
+		m.pull.progress = progressModel.(progress.Model)
+		return m, cmd
+	}
+	m.pull.input, cmd = m.pull.input.Update(msg)
+	return m, cmd
+}
+
+func (m model) pullPageView() string {
+	var b strings.Builder
+	b.WriteString("\n" + titleStyle.Render("Pull a Model (Ollama)") + "\n\n")
+	b.WriteString(itemStyle.Render(m.pull.input.View()) + "\n\n")
+	if m.pull.status != "" {
+		b.WriteString(itemStyle.Render(m.pull.progress.View()) + "\n")
+		b.WriteString(itemStyle.Render(greyStyle.Render(m.pull.status)) + "\n")
+	}
+	if m.pull.err != nil {
+		b.WriteString(itemStyle.Render(styleRed.Render(m.pull.err.Error())) + "\n")
+	}
+	if !m.pull.pulling {
+		b.WriteString("\n" + itemStyle.Render(greyStyle.Render("enter to pull, esc to go back")) + "\n")
+	}
+	return b.String()
+}
+
+func formatSize(bytes int64) string {
+	const gb = 1 << 30
+	if bytes >= gb {
+		return fmt.Sprintf("%.1f GB", float64(bytes)/gb)
+	}
+	return fmt.Sprintf("%d MB", bytes>>20)
+}
@@ -0,0 +1,131 @@
+package environment
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	. "q/types"
+	"q/util"
+	"runtime"
+	"strings"
+)
+
+// The items that can be left out with preferences.environment.exclude.
+const (
+	ItemOS    = "os"
+	ItemShell = "shell"
+	ItemCwd   = "cwd"
+	ItemTools = "tools"
+	ItemAll   = "all"
+)
+
+// DefaultTools are the tools whose presence changes what command is best.
+var DefaultTools = []string{"jq", "rg", "fd", "gsed", "docker", "kubectl"}
+
+// Info describes the machine q runs on. Empty fields were left out.
+type Info struct {
+	OS           string
+	Shell        string
+	Cwd          string
+	Installed    []string
+	NotInstalled []string
+}
+
+// Collect gathers the environment, minus the excluded items.
+func Collect(config *EnvironmentConfig) Info {
+	if config == nil {
+		config = &EnvironmentConfig{}
+	}
+	excluded := func(item string) bool {
+		for _, e := range config.Exclude {
+			if e == item || e == ItemAll {
+				return true
+			}
+		}
+		return false
+	}
+
+	var info Info
+	if !excluded(ItemOS) {
+		info.OS = osName()
+	}
+	if !excluded(ItemShell) {
+		info.Shell = util.ShellName()
+	}
+	if !excluded(ItemCwd) {
+		info.Cwd, _ = os.Getwd()
+	}
+	if !excluded(ItemTools) {
+		tools := config.Tools
+		if len(tools) == 0 {
+			tools = DefaultTools
+		}
+		for _, tool := range tools {
+			if _, err := exec.LookPath(tool); err == nil {
+				info.Installed = append(info.Installed, tool)
+			} else {
+				info.NotInstalled = append(info.NotInstalled, tool)
+			}
+		}
+	}
+	return info
+}
+
+// Describe formats the environment for the system message, or returns ""
+// if everything was left out.
+func (i Info) Describe() string {
+	var lines []string
+	add := func(label, value string) {
+		if value != "" {
+			lines = append(lines, "- "+label+": "+value)
+		}
+	}
+	add("OS", i.OS)
+	add("Shell", i.Shell)
+	add("Working directory", i.Cwd)
+	add("Installed tools", strings.Join(i.Installed, ", "))
+	add("Not installed", strings.Join(i.NotInstalled, ", "))
+	if len(lines) == 0 {
+		return ""
+	}
+	return "The user's environment, write commands that work in it:\n" + strings.Join(lines, "\n")
+}
+
+// osName names the OS and, where it matters for command flags, the flavor
+// of its userland.
+func osName() string {
+	arch := ", " + runtime.GOARCH
+	switch runtime.GOOS {
+	case "darwin":
+		name := "macOS"
+		if out, err := exec.Command("sw_vers", "-productVersion").Output(); err == nil {
+			name += " " + strings.TrimSpace(string(out))
+		}
+		return name + arch + ", BSD userland"
+	case "linux":
+		name := "Linux"
+		if distro := linuxDistro(); distro != "" {
+			name = distro
+		}
+		return name + arch
+	case "windows":
+		return "Windows" + arch
+	}
+	return runtime.GOOS + arch
+}
+
+// linuxDistro reads the distribution's name from /etc/os-release.
+func linuxDistro() string {
+	f, err := os.Open("/etc/os-release")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value := strings.TrimPrefix(scanner.Text(), "PRETTY_NAME="); value != scanner.Text() {
+			return strings.Trim(value, `"'`)
+		}
+	}
+	return ""
+}
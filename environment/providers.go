@@ -0,0 +1,193 @@
+package environment
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	. "q/types"
+	"q/util"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProviderTimeout  = 2 * time.Second
+	defaultProviderMaxBytes = 4 * 1024
+)
+
+// Result is what one context provider produced.
+type Result struct {
+	Name      string
+	Content   string
+	Truncated bool
+	Err       error
+}
+
+// Summary describes the result in a few words, for the UI.
+func (r Result) Summary() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%s (%v)", r.Name, r.Err)
+	}
+	if r.Truncated {
+		return fmt.Sprintf("%s (truncated to %s)", r.Name, formatBytes(len(r.Content)))
+	}
+	return r.Name
+}
+
+// SelectProviders picks the providers to run: the ones marked always, and
+// the ones named. Naming an unknown provider is an error.
+func SelectProviders(providers []ContextProvider, names []string) ([]ContextProvider, error) {
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+	var selected []ContextProvider
+	for _, p := range providers {
+		if p.Always || wanted[p.Name] {
+			selected = append(selected, p)
+		}
+		delete(wanted, p.Name)
+	}
+	for name := range wanted {
+		available := make([]string, len(providers))
+		for i, p := range providers {
+			available[i] = p.Name
+		}
+		if len(available) == 0 {
+			return nil, fmt.Errorf("unknown context %q, no contexts are configured", name)
+		}
+		return nil, fmt.Errorf("unknown context %q, configured contexts are: %s", name, strings.Join(available, ", "))
+	}
+	return selected, nil
+}
+
+// RunProviders runs the providers in parallel, returning their results in
+// the same order.
+func RunProviders(providers []ContextProvider) []Result {
+	results := make([]Result, len(providers))
+	var wg sync.WaitGroup
+	for i, p := range providers {
+		wg.Add(1)
+		go func(i int, p ContextProvider) {
+			defer wg.Done()
+			results[i] = runProvider(p)
+		}(i, p)
+	}
+	wg.Wait()
+	return results
+}
+
+func runProvider(p ContextProvider) Result {
+	maxBytes := p.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultProviderMaxBytes
+	}
+	var content string
+	var truncated bool
+	var err error
+	switch {
+	case p.Command != "":
+		content, truncated, err = runCommand(p, maxBytes)
+	case p.File != "":
+		content, truncated, err = readFile(p.File, maxBytes)
+	case p.Env != "":
+		value, ok := os.LookupEnv(p.Env)
+		if !ok {
+			err = fmt.Errorf("$%s is not set", p.Env)
+		}
+		content, truncated = capString(value, maxBytes)
+	default:
+		err = fmt.Errorf("no command, file or env set")
+	}
+	return Result{
+		Name:      p.Name,
+		Content:   strings.TrimRight(content, "\n"),
+		Truncated: truncated,
+		Err:       err,
+	}
+}
+
+// runCommand runs the provider's command in the user's shell, killing it if
+// it takes longer than the timeout. Its stdout and stderr are combined.
+func runCommand(p ContextProvider, maxBytes int) (string, bool, error) {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultProviderTimeout
+	}
+	cmd := util.ShellCommand(p.Command)
+	out := &capWriter{limit: maxBytes}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Start(); err != nil {
+		return "", false, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			return out.buf.String(), out.truncated, fmt.Errorf("command failed: %v", err)
+		}
+		return out.buf.String(), out.truncated, nil
+	case <-time.After(timeout):
+		// don't wait for it, children of the shell may keep the output open
+		cmd.Process.Kill()
+		return "", false, fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+func readFile(path string, maxBytes int) (string, bool, error) {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[2:])
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+	// read one byte past the limit to know if it was truncated
+	data, err := io.ReadAll(io.LimitReader(f, int64(maxBytes)+1))
+	if err != nil {
+		return "", false, err
+	}
+	content, truncated := capString(string(data), maxBytes)
+	return content, truncated, nil
+}
+
+func capString(s string, maxBytes int) (string, bool) {
+	if len(s) <= maxBytes {
+		return s, false
+	}
+	return s[:maxBytes], true
+}
+
+// capWriter keeps the first limit bytes written to it.
+type capWriter struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (w *capWriter) Write(p []byte) (int, error) {
+	room := w.limit - w.buf.Len()
+	if len(p) > room {
+		w.truncated = true
+		if room > 0 {
+			w.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return w.buf.Write(p)
+}
+
+func formatBytes(n int) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	return fmt.Sprintf("%.1fKB", float64(n)/1024)
+}
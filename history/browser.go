@@ -0,0 +1,222 @@
+package history
+
+import (
+	"fmt"
+	"io"
+	"q/util"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const browserHeight = 20
+
+var (
+	titleStyle        = lipgloss.NewStyle().MarginLeft(2).Foreground(lipgloss.Color("240"))
+	itemStyle         = lipgloss.NewStyle().PaddingLeft(4)
+	selectedItemStyle = lipgloss.NewStyle().PaddingLeft(2).Foreground(lipgloss.Color("170"))
+	detailStyle       = lipgloss.NewStyle().PaddingLeft(4).Faint(true)
+	helpStyle         = list.DefaultStyles().HelpStyle.PaddingLeft(4).PaddingBottom(1)
+)
+
+// ActionKind is what the user chose to do with an entry in the browser.
+type ActionKind int
+
+const (
+	ActionNone ActionKind = iota
+	ActionCopy
+	ActionRerun
+	ActionContinue
+)
+
+// Action is returned by Browse for the caller to carry out.
+type Action struct {
+	Kind    ActionKind
+	Session *Session
+	Turn    Turn
+}
+
+var browserKeys = struct {
+	preview, copy, rerun, resume, back key.Binding
+}{
+	preview: key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "preview")),
+	copy:    key.NewBinding(key.WithKeys("c"), key.WithHelp("c", "copy command")),
+	rerun:   key.NewBinding(key.WithKeys("r"), key.WithHelp("r", "re-run")),
+	resume:  key.NewBinding(key.WithKeys("o"), key.WithHelp("o", "continue")),
+	back:    key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "back")),
+}
+
+// turnItem is one past query in the browser.
+type turnItem struct {
+	session *Session
+	turn    Turn
+}
+
+// FilterValue lets the list's fuzzy filter match both queries and commands.
+func (i turnItem) FilterValue() string { return i.turn.Query + " " + i.turn.Command }
+
+type turnDelegate struct{}
+
+func (d turnDelegate) Height() int                             { return 2 }
+func (d turnDelegate) Spacing() int                            { return 1 }
+func (d turnDelegate) Update(_ tea.Msg, _ *list.Model) tea.Cmd { return nil }
+func (d turnDelegate) Render(w io.Writer, m list.Model, index int, listItem list.Item) {
+	i, ok := listItem.(turnItem)
+	if !ok {
+		return
+	}
+	width := m.Width() - 6
+	query := util.Truncate(strings.Join(strings.Fields(i.turn.Query), " "), width)
+	title := itemStyle.Render(query)
+	if index == m.Index() {
+		title = selectedItemStyle.Render("> " + query)
+	}
+	details := i.turn.Time.Local().Format("Jan 02 15:04") + " · " + i.turn.Model
+	if i.turn.Command != "" {
+		command := strings.Join(strings.Fields(i.turn.Command), " ")
+		details = util.Truncate("$ "+command, width-len(details)-3) + " · " + details
+	}
+	fmt.Fprintf(w, "%s\n%s", title, detailStyle.Render(details))
+}
+
+type browser struct {
+	list    list.Model
+	preview *viewport.Model
+	action  Action
+
+	width, height int
+	quitting      bool
+}
+
+// Browse shows the past queries of the sessions, newest first, and returns
+// what the user chose to do with one of them.
+func Browse(sessions []*Session) (Action, error) {
+	var items []list.Item
+	for _, session := range sessions {
+		for i := len(session.Turns) - 1; i >= 0; i-- {
+			items = append(items, turnItem{session: session, turn: session.Turns[i]})
+		}
+	}
+	l := list.New(items, turnDelegate{}, 80, browserHeight)
+	l.Title = "History"
+	l.Styles.Title = titleStyle
+	l.Styles.HelpStyle = helpStyle
+	l.SetShowStatusBar(false)
+	l.AdditionalShortHelpKeys = func() []key.Binding {
+		return []key.Binding{browserKeys.preview, browserKeys.copy, browserKeys.rerun, browserKeys.resume}
+	}
+
+	m, err := tea.NewProgram(browser{list: l}).Run()
+	if err != nil {
+		return Action{}, err
+	}
+	return m.(browser).action, nil
+}
+
+func (m browser) Init() tea.Cmd {
+	return nil
+}
+
+func (m browser) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = util.Clamp(msg.Width, 20, 100)
+		m.height = util.Clamp(msg.Height-2, 8, browserHeight)
+		m.list.SetSize(m.width, m.height)
+		if m.preview != nil {
+			m.preview.Width, m.preview.Height = m.width, m.height-2
+		}
+		return m, nil
+
+	case tea.KeyMsg:
+		if msg.Type == tea.KeyCtrlC {
+			return m.quit(ActionNone)
+		}
+		if m.preview != nil {
+			return m.updatePreview(msg)
+		}
+		// while typing a filter, keys go to the filter
+		if m.list.SettingFilter() {
+			break
+		}
+		switch {
+		case key.Matches(msg, browserKeys.preview):
+			return m.openPreview()
+		case key.Matches(msg, browserKeys.copy):
+			return m.quit(ActionCopy)
+		case key.Matches(msg, browserKeys.rerun):
+			return m.quit(ActionRerun)
+		case key.Matches(msg, browserKeys.resume):
+			return m.quit(ActionContinue)
+		}
+	}
+
+	var cmd tea.Cmd
+	m.list, cmd = m.list.Update(msg)
+	return m, cmd
+}
+
+func (m browser) updatePreview(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, browserKeys.back), msg.String() == "q":
+		m.preview = nil
+		return m, nil
+	case key.Matches(msg, browserKeys.copy):
+		return m.quit(ActionCopy)
+	case key.Matches(msg, browserKeys.rerun):
+		return m.quit(ActionRerun)
+	case key.Matches(msg, browserKeys.resume):
+		return m.quit(ActionContinue)
+	}
+	preview, cmd := m.preview.Update(msg)
+	m.preview = &preview
+	return m, cmd
+}
+
+func (m browser) openPreview() (tea.Model, tea.Cmd) {
+	item, ok := m.list.SelectedItem().(turnItem)
+	if !ok {
+		return m, nil
+	}
+	width, height := m.width, m.height
+	if width == 0 {
+		width, height = 80, browserHeight
+	}
+	r, _ := glamour.NewTermRenderer(
+		glamour.WithAutoStyle(),
+		glamour.WithWordWrap(width-4),
+	)
+	rendered, err := r.Render(item.turn.Response)
+	if err != nil {
+		rendered = item.turn.Response
+	}
+	query := selectedItemStyle.Width(width).Render("> " + item.turn.Query)
+	preview := viewport.New(width, height-2)
+	preview.SetContent(query + "\n" + rendered)
+	m.preview = &preview
+	return m, nil
+}
+
+func (m browser) quit(kind ActionKind) (tea.Model, tea.Cmd) {
+	if item, ok := m.list.SelectedItem().(turnItem); ok && kind != ActionNone {
+		m.action = Action{Kind: kind, Session: item.session, Turn: item.turn}
+	}
+	m.quitting = true
+	return m, tea.Quit
+}
+
+func (m browser) View() string {
+	if m.quitting {
+		return ""
+	}
+	if m.preview != nil {
+		help := helpStyle.Render(fmt.Sprintf("esc back · c copy command · r re-run · o continue · %3.f%%", m.preview.ScrollPercent()*100))
+		return "\n" + m.preview.View() + "\n" + help
+	}
+	return "\n" + m.list.View()
+}
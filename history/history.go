@@ -0,0 +1,169 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"q/config"
+	. "q/types"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var historyDirPath string = ".shell-ai/history"
+
+// Turn is one query in a session and what came back.
+type Turn struct {
+	Time     time.Time `json:"time"`
+	Query    string    `json:"query"`
+	Response string    `json:"response"`
+	// Command is the code extracted from the response, if any.
+	Command string `json:"command,omitempty"`
+	Model   string `json:"model"`
+}
+
+// Session is a saved conversation. Messages is the conversation as the
+// client holds it (without the model's prompt), and is what gets sent when
+// the session is resumed. Turns is the readable record of it. Mode is
+// set when the session isn't generating commands, like "explain".
+type Session struct {
+	ID       string    `json:"id"`
+	Started  time.Time `json:"started"`
+	Updated  time.Time `json:"updated"`
+	Cwd      string    `json:"cwd"`
+	Model    string    `json:"model"`
+	Mode     string    `json:"mode,omitempty"`
+	Messages []Message `json:"messages"`
+	Turns    []Turn    `json:"turns"`
+}
+
+// New starts a session in the current directory. It isn't written to disk
+// until the first Save.
+func New(model string) *Session {
+	now := time.Now()
+	cwd, _ := os.Getwd()
+	return &Session{
+		ID:      fmt.Sprintf("%s-%04x", now.Format("20060102-150405"), now.Nanosecond()&0xffff),
+		Started: now,
+		Cwd:     cwd,
+		Model:   model,
+	}
+}
+
+// refPattern matches what can name a session on the command line: its
+// number in the list, or its ID.
+var refPattern = regexp.MustCompile(`^([0-9]+|[0-9]{8}-[0-9]{6}-[0-9a-f]{4})$`)
+
+// IsRef reports whether s names a session rather than being, say, the first
+// word of a query.
+func IsRef(s string) bool {
+	return refPattern.MatchString(s)
+}
+
+// Title is the session's first query, for listings.
+func (s *Session) Title() string {
+	if len(s.Turns) == 0 {
+		return "(empty)"
+	}
+	return strings.Join(strings.Fields(s.Turns[0].Query), " ")
+}
+
+// LastTurn returns the latest turn, if there is one.
+func (s *Session) LastTurn() (Turn, bool) {
+	if len(s.Turns) == 0 {
+		return Turn{}, false
+	}
+	return s.Turns[len(s.Turns)-1], true
+}
+
+func (s *Session) AddTurn(turn Turn) {
+	s.Turns = append(s.Turns, turn)
+}
+
+func dir() (string, error) {
+	return config.FullFilePath(historyDirPath)
+}
+
+// Save writes the session to ~/.shell-ai/history/<id>.json.
+func (s *Session) Save() error {
+	dirPath, err := dir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return fmt.Errorf("error creating directories: %s", err)
+	}
+	s.Updated = time.Now()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling session: %s", err)
+	}
+	// write then rename, so a crash can't leave half a session behind
+	filePath := filepath.Join(dirPath, s.ID+".json")
+	tmpPath := filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("error writing session: %s", err)
+	}
+	return os.Rename(tmpPath, filePath)
+}
+
+// Load reads the session with the given ID.
+func Load(id string) (*Session, error) {
+	dirPath, err := dir()
+	if err != nil {
+		return nil, err
+	}
+	return loadFile(filepath.Join(dirPath, id+".json"))
+}
+
+func loadFile(filePath string) (*Session, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading session: %s", err)
+	}
+	session := &Session{}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, fmt.Errorf("error unmarshalling session %s: %s", filepath.Base(filePath), err)
+	}
+	return session, nil
+}
+
+// List returns the saved sessions, most recently updated first. Sessions
+// that can't be read are skipped.
+func List() ([]*Session, error) {
+	dirPath, err := dir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dirPath, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var sessions []*Session
+	for _, file := range files {
+		session, err := loadFile(file)
+		if err != nil {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Updated.After(sessions[j].Updated)
+	})
+	return sessions, nil
+}
+
+// Latest returns the most recently updated session.
+func Latest() (*Session, error) {
+	sessions, err := List()
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, fmt.Errorf("no saved sessions yet")
+	}
+	return sessions[0], nil
+}
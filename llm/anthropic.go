@@ -0,0 +1,112 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	. "q/types"
+)
+
+const (
+	anthropicDefaultEndpoint  = "https://api.anthropic.com/v1/messages"
+	anthropicVersion          = "2023-06-01"
+	anthropicDefaultMaxTokens = 1024
+)
+
+// anthropicBackend speaks the Anthropic Messages API.
+type anthropicBackend struct{}
+
+type anthropicPayload struct {
+	Model       string    `json:"model"`
+	System      string    `json:"system,omitempty"`
+	Messages    []Message `json:"messages"`
+	MaxTokens   int       `json:"max_tokens"`
+	Temperature float32   `json:"temperature"`
+	Stream      bool      `json:"stream"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	Usage anthropicUsage `json:"usage"`
+	Delta struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"delta"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (anthropicBackend) createRequest(config ModelConfig, messages []Message) (*http.Request, error) {
+	system, conversation := splitSystemPrompt(messages)
+	payload := anthropicPayload{
+		Model:       config.ModelName,
+		System:      system,
+		Messages:    mergeConsecutiveRoles(conversation),
+		MaxTokens:   config.MaxTokens,
+		Temperature: 0,
+		Stream:      true,
+	}
+	if payload.MaxTokens == 0 {
+		payload.MaxTokens = anthropicDefaultMaxTokens
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = anthropicDefaultEndpoint
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("x-api-key", config.Auth)
+	req.Header.Set("anthropic-version", anthropicVersion)
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+func (anthropicBackend) processStream(body io.Reader, onContent func(string)) (Usage, error) {
+	var usage Usage
+	err := readSSE(body, func(data string) (bool, error) {
+		var event anthropicEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			// skip data that isn't an event
+			return false, nil
+		}
+		switch event.Type {
+		case "message_start":
+			usage.PromptTokens = event.Message.Usage.InputTokens
+		case "message_delta":
+			usage.CompletionTokens = event.Usage.OutputTokens
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" {
+				onContent(event.Delta.Text)
+			}
+		case "message_stop":
+			return true, nil
+		case "error":
+			return true, fmt.Errorf("stream error: %s: %s", event.Error.Type, event.Error.Message)
+		}
+		return false, nil
+	})
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage, err
+}
+
+func (anthropicBackend) describe(config ModelConfig) string {
+	return "Anthropic"
+}
@@ -0,0 +1,116 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	. "q/types"
+	"testing"
+)
+
+const anthropicStream = `event: message_start
+data: {"type":"message_start","message":{"usage":{"input_tokens":12,"output_tokens":1}}}
+
+event: ping
+data: {"type":"ping"}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"` + "```bash\\n" + `"}}
+
+: keep-alive comment
+data: not json
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"ls -la\n` + "```" + `"}}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":7}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+
+func TestAnthropicQuery(t *testing.T) {
+	var got struct {
+		header  http.Header
+		payload anthropicPayload
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.header = r.Header.Clone()
+		if err := json.NewDecoder(r.Body).Decode(&got.payload); err != nil {
+			t.Errorf("decoding the request: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, anthropicStream)
+	}))
+	defer server.Close()
+
+	client := NewLLMClient(ModelConfig{
+		ModelName: "claude-test",
+		Provider:  ProviderAnthropic,
+		Endpoint:  server.URL,
+		Auth:      "test-key",
+		Prompt: []Message{
+			{Role: "system", Content: "be terse"},
+			{Role: "user", Content: "print hi"},
+			{Role: "assistant", Content: "echo hi"},
+		},
+	})
+	client.AddContext("stdin", "some log")
+	answer, err := client.Query(context.Background(), "list files")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+
+	if want := "```bash\nls -la\n```"; answer != want {
+		t.Errorf("answer = %q, want %q", answer, want)
+	}
+	if key := got.header.Get("x-api-key"); key != "test-key" {
+		t.Errorf("x-api-key = %q, want test-key", key)
+	}
+	if version := got.header.Get("anthropic-version"); version != anthropicVersion {
+		t.Errorf("anthropic-version = %q, want %q", version, anthropicVersion)
+	}
+	if got.header.Get("Authorization") != "" {
+		t.Errorf("Authorization header sent to Anthropic")
+	}
+	if got.payload.System != "be terse" {
+		t.Errorf("system = %q, want the prompt's system message", got.payload.System)
+	}
+	if !got.payload.Stream || got.payload.MaxTokens != anthropicDefaultMaxTokens || got.payload.Model != "claude-test" {
+		t.Errorf("payload = %+v, want a stream from claude-test with the default max_tokens", got.payload)
+	}
+	// the context and the query are merged, as turns have to alternate
+	messages := got.payload.Messages
+	if len(messages) != 3 {
+		t.Fatalf("messages = %+v, want the example pair and the query", messages)
+	}
+	for _, m := range messages {
+		if m.Role == "system" {
+			t.Errorf("system message sent in messages: %+v", m)
+		}
+	}
+	if last := messages[2]; last.Role != "user" || last.Content != "Context from stdin:\n```\nsome log\n```\n\nlist files" {
+		t.Errorf("last message = %+v, want the context followed by the query", last)
+	}
+
+	_, usage, ok := client.LastUsage()
+	if !ok || usage.PromptTokens != 12 || usage.CompletionTokens != 7 || usage.TotalTokens != 19 {
+		t.Errorf("usage = %+v (ok %v), want 12 in and 7 out", usage, ok)
+	}
+}
+
+func TestAnthropicStreamError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"invalid_request_error\",\"message\":\"bad\"}}\n\n")
+	}))
+	defer server.Close()
+
+	client := NewLLMClient(ModelConfig{Provider: ProviderAnthropic, Endpoint: server.URL})
+	if _, err := client.Query(context.Background(), "hi"); err == nil {
+		t.Fatal("Query succeeded, want the stream error")
+	}
+}
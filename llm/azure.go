@@ -0,0 +1,130 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	. "q/types"
+	"q/util"
+	"strings"
+	"time"
+)
+
+const (
+	azureDefaultAPIVersion = "2024-10-21"
+	azureEndpointFormat    = "https://%s.openai.azure.com/openai/deployments/%s/chat/completions?api-version=%s"
+	// azureStreamUsageVersion is the first API version that accepts
+	// stream_options.
+	azureStreamUsageVersion = "2024-09-01"
+)
+
+// azureBackend speaks the OpenAI format against an Azure OpenAI deployment,
+// authenticating with an api-key or an Entra ID token.
+type azureBackend struct {
+	openAIBackend
+	token string
+}
+
+func azureDeployment(config ModelConfig) string {
+	if config.Deployment != "" {
+		return config.Deployment
+	}
+	return config.ModelName
+}
+
+func azureEndpoint(config ModelConfig) (string, error) {
+	if config.Endpoint != "" {
+		return config.Endpoint, nil
+	}
+	if config.Resource == "" {
+		return "", fmt.Errorf("azure model %s needs either an endpoint or a resource", config.ModelName)
+	}
+	apiVersion := config.APIVersion
+	if apiVersion == "" {
+		apiVersion = azureDefaultAPIVersion
+	}
+	return fmt.Sprintf(azureEndpointFormat, config.Resource, azureDeployment(config), apiVersion), nil
+}
+
+// entraToken runs the configured token command (for example
+// `az account get-access-token ... --query accessToken -o tsv`) once per
+// session and caches the result.
+func (b *azureBackend) entraToken(command string) (string, error) {
+	if b.token != "" {
+		return b.token, nil
+	}
+	out, err := util.ShellCommand(command).Output()
+	if err != nil {
+		return "", fmt.Errorf("token command failed: %w", err)
+	}
+	b.token = strings.TrimSpace(string(out))
+	if b.token == "" {
+		return "", fmt.Errorf("token command returned an empty token")
+	}
+	return b.token, nil
+}
+
+// azureStreamsUsage reports whether the endpoint's API version accepts
+// stream_options. Versions are dates, with an optional -preview suffix.
+func azureStreamsUsage(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	version := u.Query().Get("api-version")
+	if len(version) < len(azureStreamUsageVersion) {
+		return false
+	}
+	if _, err := time.Parse("2006-01-02", version[:len(azureStreamUsageVersion)]); err != nil {
+		return false
+	}
+	return version >= azureStreamUsageVersion
+}
+
+func (b *azureBackend) createRequest(config ModelConfig, messages []Message) (*http.Request, error) {
+	endpoint, err := azureEndpoint(config)
+	if err != nil {
+		return nil, err
+	}
+	payload := Payload{
+		Model:         config.ModelName,
+		Messages:      messages,
+		Temperature:   0,
+		Stream:        true,
+		StreamOptions: streamOptions(config, azureStreamsUsage(endpoint)),
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if config.TokenCommand != "" {
+		token, err := b.entraToken(config.TokenCommand)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		req.Header.Set("api-key", config.Auth)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+func (b *azureBackend) processStream(body io.Reader, onContent func(string)) (Usage, error) {
+	return b.openAIBackend.processStream(body, onContent)
+}
+
+func (b *azureBackend) describe(config ModelConfig) string {
+	description := fmt.Sprintf("Azure OpenAI deployment %q", azureDeployment(config))
+	if config.Resource != "" {
+		description += fmt.Sprintf(" on %s", config.Resource)
+	}
+	return description
+}
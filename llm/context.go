@@ -0,0 +1,214 @@
+package llm
+
+import (
+	"fmt"
+	. "q/types"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultContextWindow = 8192
+	// defaultAnswerTokens is kept free for the answer when the model has no
+	// max_tokens set.
+	defaultAnswerTokens = 1024
+	// messageOverhead approximates the tokens each message costs on top of
+	// its content (role, separators).
+	messageOverhead = 4
+	// noteQueryLength caps how much of each dropped query the note keeps.
+	noteQueryLength = 80
+	// historyShare is the part of the room (1/historyShare) kept for the
+	// newest turns and the note when the attached context doesn't fit.
+	historyShare = 4
+	// cutShortNote ends context that was cut short, closing its code block.
+	cutShortNote = "\n```\n[cut short to fit the context window]"
+)
+
+// EstimateTokens approximates how many tokens text takes up. It's tuned to
+// come out a little high for English and code with BPE tokenizers, so a
+// conversation that fits by this count fits in practice.
+func EstimateTokens(text string) int {
+	tokens := 0
+	wordLength := 0
+	endWord := func() {
+		if wordLength > 0 {
+			tokens += (wordLength + 3) / 4
+			wordLength = 0
+		}
+	}
+	for _, r := range text {
+		switch {
+		case r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			wordLength++
+		case unicode.IsSpace(r):
+			endWord()
+			if r == '\n' {
+				tokens++
+			}
+		default:
+			// punctuation and non-ASCII characters are roughly a token each
+			endWord()
+			tokens++
+		}
+	}
+	endWord()
+	return tokens
+}
+
+func estimateMessages(messages []Message) int {
+	tokens := 0
+	for _, m := range messages {
+		tokens += messageOverhead + EstimateTokens(m.Content)
+	}
+	return tokens
+}
+
+// Trimming describes what was left out of a query to fit the context window.
+type Trimming struct {
+	// Dropped counts the oldest messages left out.
+	Dropped int
+	// Shortened counts the contexts attached for the query that were cut
+	// short.
+	Shortened int
+}
+
+// fitContext trims the conversation history so that the prompt, history and
+// query fit in the model's context window with room left for the answer. The
+// prompt and query are always kept, and so is the context attached for the
+// query (like piped input), which is cut short if it doesn't fit next to a
+// share of the room kept for the history. The oldest messages are dropped
+// first and replaced by a short note listing what the user asked in them, so
+// follow ups can still refer back.
+func fitContext(config ModelConfig, prompt, history []Message, query Message) ([]Message, Trimming) {
+	window := config.ContextWindow
+	if window <= 0 {
+		window = defaultContextWindow
+	}
+	answer := config.MaxTokens
+	if answer <= 0 {
+		answer = defaultAnswerTokens
+	}
+	room := window - answer - estimateMessages(prompt) - estimateMessages([]Message{query})
+	if estimateMessages(history) <= room {
+		return history, Trimming{}
+	}
+
+	var trimming Trimming
+	// the context attached since the last answer belongs to the query
+	start := len(history)
+	for start > 0 && isContext(history[start-1]) {
+		start--
+	}
+	pending := append([]Message(nil), history[start:]...)
+	history = history[:start]
+	reserved := estimateMessages(history)
+	if reserved > room/historyShare {
+		reserved = room / historyShare
+	}
+	pendingRoom := room - reserved
+	for i, m := range pending {
+		size := estimateMessages(pending[i : i+1])
+		if size > pendingRoom {
+			pending[i].Content = cutToTokens(m.Content, pendingRoom-messageOverhead-EstimateTokens(cutShortNote)) + cutShortNote
+			size = estimateMessages(pending[i : i+1])
+			trimming.Shortened++
+		}
+		pendingRoom -= size
+		room -= size
+	}
+	if room < 0 {
+		room = 0
+	}
+
+	// keep the newest messages that fit, leaving space for the note
+	kept := len(history)
+	used := 0
+	if estimateMessages(history) <= room {
+		kept = 0
+		used = estimateMessages(history)
+	}
+	for kept > 0 {
+		size := estimateMessages(history[kept-1 : kept])
+		if used+size > room*3/4 {
+			break
+		}
+		used += size
+		kept--
+	}
+	// don't start the history with an answer to a dropped question
+	for kept < len(history) && history[kept].Role == "assistant" {
+		used -= estimateMessages(history[kept : kept+1])
+		kept++
+	}
+
+	dropped := history[:kept]
+	trimmed := append([]Message(nil), history[kept:]...)
+	if note, ok := droppedNote(dropped, room-used); ok {
+		trimmed = append([]Message{note}, trimmed...)
+	}
+	trimming.Dropped = len(dropped)
+	return append(trimmed, pending...), trimming
+}
+
+// isContext reports whether the message was attached with AddContext.
+func isContext(m Message) bool {
+	return m.Role == "user" && strings.HasPrefix(m.Content, contextPrefix)
+}
+
+// cutToTokens keeps as much of the start of text as fits in tokens.
+func cutToTokens(text string, tokens int) string {
+	if tokens <= 0 {
+		return ""
+	}
+	runes := []rune(text)
+	// the longest prefix that fits, found by bisection
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if EstimateTokens(string(runes[:mid])) <= tokens {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return string(runes[:lo])
+}
+
+// droppedNote condenses dropped messages into a list of the queries in them,
+// newest first, as long as it fits in room tokens.
+func droppedNote(dropped []Message, room int) (Message, bool) {
+	header := "Earlier in this conversation (left out to fit the context window), I asked:"
+	lines := []string{header}
+	size := messageOverhead + EstimateTokens(header)
+	for i := len(dropped) - 1; i >= 0; i-- {
+		m := dropped[i]
+		if m.Role != "user" || isContext(m) {
+			continue
+		}
+		line := "- " + summarizeQuery(m.Content)
+		lineSize := EstimateTokens(line) + 1
+		if size+lineSize > room {
+			break
+		}
+		size += lineSize
+		lines = append(lines, line)
+	}
+	if len(lines) == 1 {
+		return Message{}, false
+	}
+	// list them oldest first
+	for i, j := 1, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return Message{Role: "user", Content: strings.Join(lines, "\n")}, true
+}
+
+func summarizeQuery(query string) string {
+	query = strings.Join(strings.Fields(query), " ")
+	if utf8.RuneCountInString(query) <= noteQueryLength {
+		return query
+	}
+	runes := []rune(query)
+	return fmt.Sprintf("%s...", string(runes[:noteQueryLength]))
+}
@@ -0,0 +1,94 @@
+package llm
+
+import (
+	"fmt"
+	. "q/types"
+	"strings"
+	"testing"
+)
+
+func turns(n int) []Message {
+	var history []Message
+	for i := 1; i <= n; i++ {
+		history = append(history,
+			Message{Role: "user", Content: fmt.Sprintf("question %d %s", i, strings.Repeat("word ", 50))},
+			Message{Role: "assistant", Content: fmt.Sprintf("answer %d %s", i, strings.Repeat("word ", 50))},
+		)
+	}
+	return history
+}
+
+func contextMessage(source string, size int) Message {
+	return Message{Role: "user", Content: fmt.Sprintf("%s%s:\n```\n%s\n```", contextPrefix, source, strings.Repeat("log line\n", size))}
+}
+
+func TestFitContextKeepsWhatFits(t *testing.T) {
+	history := append(turns(3), contextMessage("stdin", 10))
+	got, trimming := fitContext(ModelConfig{}, nil, history, Message{Role: "user", Content: "why"})
+	if len(got) != len(history) || trimming != (Trimming{}) {
+		t.Errorf("fitContext trimmed %+v from a conversation that fits", trimming)
+	}
+}
+
+func TestFitContextDropsOldestTurns(t *testing.T) {
+	config := ModelConfig{ContextWindow: 1500, MaxTokens: 200}
+	query := Message{Role: "user", Content: "and now?"}
+	got, trimming := fitContext(config, nil, turns(20), query)
+
+	if trimming.Dropped == 0 || trimming.Shortened != 0 {
+		t.Fatalf("trimming = %+v, want dropped turns and nothing shortened", trimming)
+	}
+	if estimateMessages(got) > 1500-200-estimateMessages([]Message{query}) {
+		t.Errorf("history takes %d tokens, more than the room left", estimateMessages(got))
+	}
+	note := got[0].Content
+	if !strings.HasPrefix(note, "Earlier in this conversation") || !strings.Contains(note, "question 1 ") {
+		t.Errorf("first message = %q, want the note listing the dropped questions", note)
+	}
+	if last := got[len(got)-1].Content; !strings.HasPrefix(last, "answer 20 ") {
+		t.Errorf("last message = %q, want the newest answer", last)
+	}
+	if got[1].Role != "user" {
+		t.Errorf("history after the note starts with %s, want a question", got[1].Role)
+	}
+}
+
+func TestFitContextKeepsRecentTurnsBesideLargeContext(t *testing.T) {
+	// a full default stdin pipe and the default window
+	history := append(turns(3), contextMessage("stdin", 32*1024/9))
+	query := Message{Role: "user", Content: "why is this failing"}
+	got, trimming := fitContext(ModelConfig{}, nil, history, query)
+
+	if trimming.Shortened != 1 {
+		t.Errorf("trimming = %+v, want the context cut short", trimming)
+	}
+	if room := defaultContextWindow - defaultAnswerTokens - estimateMessages([]Message{query}); estimateMessages(got) > room {
+		t.Errorf("history takes %d tokens, more than the %d left", estimateMessages(got), room)
+	}
+	if len(got) < 2 {
+		t.Fatalf("fitContext kept %d messages, want the newest turns too", len(got))
+	}
+	last := got[len(got)-1]
+	if !isContext(last) || !strings.HasSuffix(last.Content, cutShortNote) {
+		t.Errorf("last message isn't the shortened context: %.80q", last.Content)
+	}
+	if previous := got[len(got)-2].Content; !strings.HasPrefix(previous, "answer 3 ") {
+		t.Errorf("message before the context = %.80q, want the newest answer", previous)
+	}
+	if trimming.Dropped > 0 && !strings.HasPrefix(got[0].Content, "Earlier in this conversation") {
+		t.Errorf("%d messages dropped without a note", trimming.Dropped)
+	}
+}
+
+func TestCutToTokens(t *testing.T) {
+	text := strings.Repeat("abcd ", 100)
+	for _, tokens := range []int{0, 1, 10, 99, 100, 1000} {
+		cut := cutToTokens(text, tokens)
+		if EstimateTokens(cut) > tokens || !strings.HasPrefix(text, cut) {
+			t.Errorf("cutToTokens(%d) = %d tokens, not a prefix that fits", tokens, EstimateTokens(cut))
+		}
+		if tokens >= 100 && cut != text {
+			t.Errorf("cutToTokens(%d) cut text that fits", tokens)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIError is returned when the provider answers with a non-200 status. The
+// code, type and message come from the error body, when the provider sends one.
+type APIError struct {
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration
+
+	Code    string
+	Type    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	message := "API request failed: " + e.Status
+	if e.Message != "" {
+		message += ": " + e.Message
+	}
+	if e.RetryAfter > 0 {
+		message += fmt.Sprintf(" (retry after %s)", e.RetryAfter.Round(time.Second))
+	}
+	return message
+}
+
+func newAPIError(resp *http.Response) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err == nil {
+		parseErrorBody(apiErr, body)
+	}
+	return apiErr
+}
+
+// parseErrorBody fills in the details of an error body. It understands the
+// shapes used by OpenAI and Azure ({"error": {"message", "type", "code"}}),
+// Anthropic ({"type": "error", "error": {"type", "message"}}), Gemini
+// ({"error": {"code", "message", "status"}}, sometimes wrapped in a list)
+// and Ollama ({"error": "message"}).
+func parseErrorBody(apiErr *APIError, body []byte) {
+	body = []byte(strings.TrimSpace(string(body)))
+	if len(body) > 0 && body[0] == '[' {
+		var list []json.RawMessage
+		if json.Unmarshal(body, &list) != nil || len(list) == 0 {
+			return
+		}
+		body = list[0]
+	}
+	var envelope struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(body, &envelope) != nil || len(envelope.Error) == 0 {
+		return
+	}
+	var message string
+	if json.Unmarshal(envelope.Error, &message) == nil {
+		apiErr.Message = message
+		return
+	}
+	var details struct {
+		Message string          `json:"message"`
+		Type    string          `json:"type"`
+		Status  string          `json:"status"`
+		Code    json.RawMessage `json:"code"`
+	}
+	if json.Unmarshal(envelope.Error, &details) != nil {
+		return
+	}
+	apiErr.Message = details.Message
+	apiErr.Type = details.Type
+	if apiErr.Type == "" {
+		apiErr.Type = details.Status
+	}
+	var code string
+	if json.Unmarshal(details.Code, &code) == nil {
+		apiErr.Code = code
+	} else if n, err := strconv.Atoi(string(details.Code)); err == nil {
+		apiErr.Code = strconv.Itoa(n)
+	}
+}
+
+type ErrorClass int
+
+const (
+	ErrorUnknown ErrorClass = iota
+	ErrorAuth
+	// ErrorQuota is out of credit or quota, which waiting won't fix.
+	ErrorQuota
+	ErrorRateLimit
+	ErrorModelMissing
+	ErrorContextOverflow
+	ErrorUnreachable
+)
+
+// ClassifyError sorts a query error into the broad causes worth giving the
+// user a specific hint for.
+func ClassifyError(err error) ErrorClass {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return classifyAPIError(apiErr)
+	}
+	var netErr net.Error
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	if errors.As(err, &opErr) || errors.As(err, &dnsErr) || errors.As(err, &netErr) {
+		return ErrorUnreachable
+	}
+	return ErrorUnknown
+}
+
+func classifyAPIError(e *APIError) ErrorClass {
+	text := strings.ToLower(strings.Join([]string{e.Code, e.Type, e.Message}, " "))
+	has := func(words ...string) bool {
+		for _, w := range words {
+			if strings.Contains(text, w) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case has("context_length", "context length", "maximum context", "too many tokens", "prompt is too long",
+		"input is too long", "exceeds the maximum number of tokens"):
+		return ErrorContextOverflow
+	case e.StatusCode == http.StatusUnauthorized, e.StatusCode == http.StatusForbidden,
+		has("invalid_api_key", "authentication", "permission", "unauthenticated", "api key"):
+		return ErrorAuth
+	case e.StatusCode == http.StatusPaymentRequired,
+		has("insufficient_quota", "exceeded your current quota", "billing", "credit balance"):
+		return ErrorQuota
+	case e.StatusCode == http.StatusTooManyRequests, has("rate_limit", "resource_exhausted", "quota"):
+		return ErrorRateLimit
+	case e.StatusCode == http.StatusNotFound, has("model_not_found", "deploymentnotfound", "not found", "does not exist"):
+		return ErrorModelMissing
+	}
+	return ErrorUnknown
+}
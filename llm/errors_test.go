@@ -0,0 +1,196 @@
+package llm
+
+import "testing"
+
+func TestAPIErrorBodies(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		code    string
+		typ     string
+		message string
+		class   ErrorClass
+		retry   bool
+	}{
+		{
+			name:    "openai bad key",
+			status:  401,
+			body:    `{"error":{"message":"Incorrect API key provided: sk-xx.","type":"invalid_request_error","param":null,"code":"invalid_api_key"}}`,
+			code:    "invalid_api_key",
+			typ:     "invalid_request_error",
+			message: "Incorrect API key provided: sk-xx.",
+			class:   ErrorAuth,
+		},
+		{
+			name:    "openai quota",
+			status:  429,
+			body:    `{"error":{"message":"You exceeded your current quota, please check your plan and billing details.","type":"insufficient_quota","param":null,"code":"insufficient_quota"}}`,
+			code:    "insufficient_quota",
+			typ:     "insufficient_quota",
+			message: "You exceeded your current quota, please check your plan and billing details.",
+			class:   ErrorQuota,
+		},
+		{
+			name:    "openai rate limit",
+			status:  429,
+			body:    `{"error":{"message":"Rate limit reached for gpt-4o in organization org-x on tokens per min (TPM): Limit 30000, Used 29000, Requested 2000. Please try again in 2s.","type":"tokens","param":null,"code":"rate_limit_exceeded"}}`,
+			code:    "rate_limit_exceeded",
+			typ:     "tokens",
+			message: "Rate limit reached for gpt-4o in organization org-x on tokens per min (TPM): Limit 30000, Used 29000, Requested 2000. Please try again in 2s.",
+			class:   ErrorRateLimit,
+			retry:   true,
+		},
+		{
+			name:    "openai context length",
+			status:  400,
+			body:    `{"error":{"message":"This model's maximum context length is 8192 tokens. However, your messages resulted in 9000 tokens. Please reduce the length of the messages.","type":"invalid_request_error","param":"messages","code":"context_length_exceeded"}}`,
+			code:    "context_length_exceeded",
+			typ:     "invalid_request_error",
+			message: "This model's maximum context length is 8192 tokens. However, your messages resulted in 9000 tokens. Please reduce the length of the messages.",
+			class:   ErrorContextOverflow,
+		},
+		{
+			name:    "openai missing model",
+			status:  404,
+			body:    "{\"error\":{\"message\":\"The model `gpt-9` does not exist or you do not have access to it.\",\"type\":\"invalid_request_error\",\"param\":null,\"code\":\"model_not_found\"}}",
+			code:    "model_not_found",
+			typ:     "invalid_request_error",
+			message: "The model `gpt-9` does not exist or you do not have access to it.",
+			class:   ErrorModelMissing,
+		},
+		{
+			name:    "openai timeout",
+			status:  504,
+			body:    `{"error":{"message":"The upstream server took too long to respond.","type":"server_error"}}`,
+			typ:     "server_error",
+			message: "The upstream server took too long to respond.",
+			class:   ErrorUnknown,
+			retry:   true,
+		},
+		{
+			name:    "azure missing deployment",
+			status:  404,
+			body:    `{"error":{"code":"DeploymentNotFound","message":"The API deployment for this resource does not exist. If you created the deployment within the last 5 minutes, please wait a moment and try again."}}`,
+			code:    "DeploymentNotFound",
+			message: "The API deployment for this resource does not exist. If you created the deployment within the last 5 minutes, please wait a moment and try again.",
+			class:   ErrorModelMissing,
+		},
+		{
+			name:    "azure rate limit",
+			status:  429,
+			body:    `{"error":{"code":"429","message":"Requests to the ChatCompletions_Create Operation under Azure OpenAI API version 2024-10-21 have exceeded token rate limit of your current OpenAI S0 pricing tier. Please retry after 6 seconds."}}`,
+			code:    "429",
+			message: "Requests to the ChatCompletions_Create Operation under Azure OpenAI API version 2024-10-21 have exceeded token rate limit of your current OpenAI S0 pricing tier. Please retry after 6 seconds.",
+			class:   ErrorRateLimit,
+			retry:   true,
+		},
+		{
+			name:    "anthropic bad key",
+			status:  401,
+			body:    `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`,
+			typ:     "authentication_error",
+			message: "invalid x-api-key",
+			class:   ErrorAuth,
+		},
+		{
+			name:    "anthropic credit",
+			status:  400,
+			body:    `{"type":"error","error":{"type":"invalid_request_error","message":"Your credit balance is too low to access the Anthropic API. Please go to Plans & Billing to upgrade or purchase credits."}}`,
+			typ:     "invalid_request_error",
+			message: "Your credit balance is too low to access the Anthropic API. Please go to Plans & Billing to upgrade or purchase credits.",
+			class:   ErrorQuota,
+		},
+		{
+			name:    "anthropic rate limit",
+			status:  429,
+			body:    `{"type":"error","error":{"type":"rate_limit_error","message":"Number of request tokens has exceeded your per-minute rate limit"}}`,
+			typ:     "rate_limit_error",
+			message: "Number of request tokens has exceeded your per-minute rate limit",
+			class:   ErrorRateLimit,
+			retry:   true,
+		},
+		{
+			name:    "anthropic prompt too long",
+			status:  400,
+			body:    `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 210000 tokens > 200000 maximum"}}`,
+			typ:     "invalid_request_error",
+			message: "prompt is too long: 210000 tokens > 200000 maximum",
+			class:   ErrorContextOverflow,
+		},
+		{
+			name:    "anthropic overloaded",
+			status:  529,
+			body:    `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			typ:     "overloaded_error",
+			message: "Overloaded",
+			class:   ErrorUnknown,
+			retry:   true,
+		},
+		{
+			name:    "gemini bad key in a list",
+			status:  400,
+			body:    `[{"error":{"code":400,"message":"API key not valid. Please pass a valid API key.","status":"INVALID_ARGUMENT"}}]`,
+			code:    "400",
+			typ:     "INVALID_ARGUMENT",
+			message: "API key not valid. Please pass a valid API key.",
+			class:   ErrorAuth,
+		},
+		{
+			name:    "gemini exhausted",
+			status:  429,
+			body:    `{"error":{"code":429,"message":"Resource has been exhausted (e.g. check quota).","status":"RESOURCE_EXHAUSTED"}}`,
+			code:    "429",
+			typ:     "RESOURCE_EXHAUSTED",
+			message: "Resource has been exhausted (e.g. check quota).",
+			class:   ErrorRateLimit,
+			retry:   true,
+		},
+		{
+			name:    "gemini token count",
+			status:  400,
+			body:    `{"error":{"code":400,"message":"The input token count (1200000) exceeds the maximum number of tokens allowed (1048576).","status":"INVALID_ARGUMENT"}}`,
+			code:    "400",
+			typ:     "INVALID_ARGUMENT",
+			message: "The input token count (1200000) exceeds the maximum number of tokens allowed (1048576).",
+			class:   ErrorContextOverflow,
+		},
+		{
+			name:    "gemini missing model",
+			status:  404,
+			body:    `{"error":{"code":404,"message":"models/gemini-9 is not found for API version v1beta, or is not supported for generateContent.","status":"NOT_FOUND"}}`,
+			code:    "404",
+			typ:     "NOT_FOUND",
+			message: "models/gemini-9 is not found for API version v1beta, or is not supported for generateContent.",
+			class:   ErrorModelMissing,
+		},
+		{
+			name:    "ollama missing model",
+			status:  404,
+			body:    `{"error":"model \"llama9\" not found, try pulling it first"}`,
+			message: `model "llama9" not found, try pulling it first`,
+			class:   ErrorModelMissing,
+		},
+		{
+			name:   "html from a proxy",
+			status: 502,
+			body:   "<html><body>502 Bad Gateway</body></html>",
+			class:  ErrorUnknown,
+			retry:  true,
+		},
+	}
+	for _, tt := range tests {
+		apiErr := &APIError{StatusCode: tt.status}
+		parseErrorBody(apiErr, []byte(tt.body))
+		if apiErr.Code != tt.code || apiErr.Type != tt.typ || apiErr.Message != tt.message {
+			t.Errorf("%s: parsed code %q, type %q, message %q; want %q, %q, %q",
+				tt.name, apiErr.Code, apiErr.Type, apiErr.Message, tt.code, tt.typ, tt.message)
+		}
+		if class := classifyAPIError(apiErr); class != tt.class {
+			t.Errorf("%s: class = %d, want %d", tt.name, class, tt.class)
+		}
+		if _, retry := retryReason(apiErr); retry != tt.retry {
+			t.Errorf("%s: retry = %v, want %v", tt.name, retry, tt.retry)
+		}
+	}
+}
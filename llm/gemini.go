@@ -0,0 +1,117 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	. "q/types"
+)
+
+const geminiDefaultEndpoint = "https://generativelanguage.googleapis.com/v1beta/models/%s:streamGenerateContent?alt=sse"
+
+// geminiBackend speaks the Google Gemini streamGenerateContent API.
+type geminiBackend struct{}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPayload struct {
+	Contents          []geminiContent `json:"contents"`
+	SystemInstruction *geminiContent  `json:"systemInstruction,omitempty"`
+	GenerationConfig  struct {
+		Temperature     float32 `json:"temperature"`
+		MaxOutputTokens int     `json:"maxOutputTokens,omitempty"`
+	} `json:"generationConfig"`
+}
+
+type geminiChunk struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		TotalTokenCount      int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+func (geminiBackend) createRequest(config ModelConfig, messages []Message) (*http.Request, error) {
+	system, conversation := splitSystemPrompt(messages)
+	payload := geminiPayload{}
+	if system != "" {
+		payload.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: system}}}
+	}
+	for _, m := range mergeConsecutiveRoles(conversation) {
+		role := "user"
+		if m.Role == "assistant" {
+			role = "model"
+		}
+		payload.Contents = append(payload.Contents, geminiContent{
+			Role:  role,
+			Parts: []geminiPart{{Text: m.Content}},
+		})
+	}
+	payload.GenerationConfig.MaxOutputTokens = config.MaxTokens
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf(geminiDefaultEndpoint, config.ModelName)
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("x-goog-api-key", config.Auth)
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+func (geminiBackend) processStream(body io.Reader, onContent func(string)) (Usage, error) {
+	var usage Usage
+	err := readSSE(body, func(data string) (bool, error) {
+		var chunk geminiChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			// skip data that isn't an event
+			return false, nil
+		}
+		if chunk.Error.Message != "" {
+			return true, fmt.Errorf("stream error: %s: %s", chunk.Error.Status, chunk.Error.Message)
+		}
+		if chunk.UsageMetadata.PromptTokenCount > 0 {
+			usage = Usage{
+				PromptTokens:     chunk.UsageMetadata.PromptTokenCount,
+				CompletionTokens: chunk.UsageMetadata.CandidatesTokenCount,
+				TotalTokens:      chunk.UsageMetadata.PromptTokenCount + chunk.UsageMetadata.CandidatesTokenCount,
+			}
+		}
+		if len(chunk.Candidates) == 0 {
+			return false, nil
+		}
+		for _, part := range chunk.Candidates[0].Content.Parts {
+			onContent(part.Text)
+		}
+		return false, nil
+	})
+	return usage, err
+}
+
+func (geminiBackend) describe(config ModelConfig) string {
+	return "Google Gemini"
+}
@@ -2,28 +2,105 @@ package llm
 
 import (
 	"bufio"
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 	. "q/types"
 	"strings"
 	"time"
 )
 
+const (
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
+	ProviderOllama    = "ollama"
+	ProviderGemini    = "gemini"
+	ProviderAzure     = "azure"
+)
+
+// backend speaks the wire format of one provider.
+type backend interface {
+	createRequest(config ModelConfig, messages []Message) (*http.Request, error)
+	processStream(body io.Reader, onContent func(string)) (Usage, error)
+	// describe names the service for error messages.
+	describe(config ModelConfig) string
+}
+
+func newBackend(config ModelConfig) (backend, error) {
+	switch config.Provider {
+	case "", ProviderOpenAI:
+		// older configs point the OpenAI provider at Azure endpoints
+		if strings.Contains(config.Endpoint, "openai.azure.com") {
+			return &azureBackend{}, nil
+		}
+		return openAIBackend{}, nil
+	case ProviderAzure:
+		return &azureBackend{}, nil
+	case ProviderAnthropic:
+		return anthropicBackend{}, nil
+	case ProviderOllama:
+		return ollamaBackend{}, nil
+	case ProviderGemini:
+		return geminiBackend{}, nil
+	}
+	return nil, fmt.Errorf("unknown provider %q for model %s", config.Provider, config.ModelName)
+}
+
+// route is one model the client can send queries to.
+type route struct {
+	config  ModelConfig
+	backend backend
+}
+
+func (r *route) getBackend() (backend, error) {
+	if r.backend == nil {
+		b, err := newBackend(r.config)
+		if err != nil {
+			return nil, err
+		}
+		r.backend = b
+	}
+	return r.backend, nil
+}
+
 type LLMClient struct {
-	config   ModelConfig
-	messages []Message
+	// routes holds the primary model followed by its fallbacks.
+	routes []*route
+	// messages holds the conversation so far, without the model's prompt.
+	messages   []Message
+	answeredBy string
+	// prompt replaces the models' own prompts when set.
+	prompt []Message
+	// systemContext is added to the system message of every model's prompt.
+	systemContext []string
+	// lastUsage is what the last query cost, on the model that answered it.
+	lastUsage  Usage
+	lastConfig ModelConfig
+	// lastTrimmed is what was left out of the last query to fit the context
+	// window.
+	lastTrimmed Trimming
 
 	StreamCallback func(string, error)
+	// RetryCallback is told why a request is being retried and how long
+	// until the next attempt.
+	RetryCallback func(reason string, wait time.Duration)
+	// BeforeQuery is asked before each model is tried. If it returns an
+	// error the model is skipped, as if the query to it had failed.
+	BeforeQuery func(config ModelConfig) error
 
 	httpClient *http.Client
 }
 
-func NewLLMClient(config ModelConfig) *LLMClient {
+// NewLLMClient creates a client for the given model. If a query to it fails,
+// the fallbacks are tried in order with the same conversation.
+func NewLLMClient(config ModelConfig, fallbacks ...ModelConfig) *LLMClient {
+	routes := []*route{{config: config}}
+	for _, fallback := range fallbacks {
+		routes = append(routes, &route{config: fallback})
+	}
 	return &LLMClient{
-		config:   config,
-		messages: append([]Message(nil), config.Prompt...),
+		routes: routes,
 
 		httpClient: &http.Client{
 			Timeout: time.Second * 120,
@@ -31,97 +108,279 @@ func NewLLMClient(config ModelConfig) *LLMClient {
 	}
 }
 
-func (c *LLMClient) createRequest(payload Payload) (*http.Request, error) {
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+// AddMessage appends a message to the conversation without querying the model.
+func (c *LLMClient) AddMessage(message Message) {
+	c.messages = append(c.messages, message)
+}
+
+// AddSystemContext adds text to the system message sent to every model, for
+// facts that should shape all answers (like the user's OS and shell).
+func (c *LLMClient) AddSystemContext(text string) {
+	c.systemContext = append(c.systemContext, text)
+}
+
+// SetPrompt replaces the prompt of every model, to answer differently from
+// what the configured prompts ask for (like explaining a command).
+func (c *LLMClient) SetPrompt(prompt []Message) {
+	c.prompt = append([]Message(nil), prompt...)
+}
+
+// systemPrompt is the model's prompt with the system context added to its
+// system message, or in a new one if it has none.
+func (c *LLMClient) systemPrompt(config ModelConfig) []Message {
+	prompt := append([]Message(nil), config.Prompt...)
+	if c.prompt != nil {
+		prompt = append([]Message(nil), c.prompt...)
 	}
-	req, err := http.NewRequest("POST", c.config.Endpoint, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	if len(c.systemContext) == 0 {
+		return prompt
+	}
+	extra := strings.Join(c.systemContext, "\n\n")
+	if len(prompt) > 0 && prompt[0].Role == "system" {
+		prompt[0].Content += "\n\n" + extra
+		return prompt
 	}
-	if strings.Contains(c.config.Endpoint, "openai.azure.com") {
-		req.Header.Set("Api-Key", c.config.Auth)
-	} else {
-		req.Header.Set("Authorization", "Bearer "+c.config.Auth)
+	return append([]Message{{Role: "system", Content: extra}}, prompt...)
+}
+
+// Messages returns the conversation so far, without the model's prompt.
+func (c *LLMClient) Messages() []Message {
+	return append([]Message(nil), c.messages...)
+}
+
+// SetMessages replaces the conversation, to resume an earlier one.
+func (c *LLMClient) SetMessages(messages []Message) {
+	c.messages = append([]Message(nil), messages...)
+}
+
+// contextPrefix starts the messages added by AddContext.
+const contextPrefix = "Context from "
+
+// AddContext attaches supporting material (like piped input) to the
+// conversation as its own message, sent along with the next query. It
+// reports false if the conversation already holds the same context, as a
+// resumed one can, and adds nothing.
+func (c *LLMClient) AddContext(source, content string) bool {
+	message := Message{
+		Role:    "user",
+		Content: fmt.Sprintf("%s%s:\n```\n%s\n```", contextPrefix, source, content),
 	}
-	if c.config.OrgID != "" {
-		req.Header.Set("OpenAI-Organization", c.config.OrgID)
+	for _, m := range c.messages {
+		if m == message {
+			return false
+		}
 	}
-	req.Header.Set("Content-Type", "application/json")
-	return req, nil
+	c.AddMessage(message)
+	return true
 }
 
-func (c *LLMClient) Query(query string) (string, error) {
-	messages := c.messages
-	messages = append(messages, Message{Role: "user", Content: query})
+// Query sends the query along with the conversation so far, falling back to
+// the next model if one fails before answering. If ctx is cancelled
+// mid-stream, the partial answer is kept in the conversation and returned
+// along with the context's error.
+func (c *LLMClient) Query(ctx context.Context, query string) (string, error) {
+	userMessage := Message{Role: "user", Content: query}
+	c.lastUsage = Usage{}
+	var firstErr error
+	var fallbackErrs []string
+	for _, r := range c.routes {
+		message, usage, err := c.tryRoute(ctx, r, userMessage)
+		if err != nil && ctx.Err() != nil && message.Content != "" {
+			c.answered(r, userMessage, message, usage)
+			return message.Content, ctx.Err()
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if err == nil {
+			c.answered(r, userMessage, message, usage)
+			return message.Content, nil
+		}
+		// a half-streamed answer can't be taken back, so don't fall back,
+		// but what it cost still counts
+		if message.Content != "" {
+			c.lastConfig = r.config
+			c.lastUsage = usage
+			return "", err
+		}
+		if firstErr == nil {
+			firstErr = err
+		} else {
+			fallbackErrs = append(fallbackErrs, fmt.Sprintf("fallback %s: %v", r.config.ModelName, err))
+		}
+	}
+	if len(fallbackErrs) > 0 {
+		return "", fmt.Errorf("%w (%s)", firstErr, strings.Join(fallbackErrs, "; "))
+	}
+	return "", firstErr
+}
 
-	payload := Payload{
-		Model:       c.config.ModelName,
-		Messages:    messages,
-		Temperature: 0,
-		Stream:      true,
+// tryRoute sends the conversation and the new query to one model.
+func (c *LLMClient) tryRoute(ctx context.Context, r *route, query Message) (Message, Usage, error) {
+	if c.BeforeQuery != nil {
+		if err := c.BeforeQuery(r.config); err != nil {
+			return Message{}, Usage{}, err
+		}
 	}
+	prompt := c.systemPrompt(r.config)
+	history, trimmed := fitContext(r.config, prompt, c.messages, query)
+	c.lastTrimmed = trimmed
+	messages := append(prompt, history...)
+	messages = append(messages, query)
+	return c.callStream(ctx, r, messages)
+}
 
-	message, err := c.callStream(payload)
+func (c *LLMClient) answered(r *route, query, answer Message, usage Usage) {
+	c.messages = append(c.messages, query, answer)
+	c.answeredBy = r.config.ModelName
+	c.lastConfig = r.config
+	c.lastUsage = usage
+}
+
+// LastUsage reports the tokens spent on the last query, even if it was
+// cancelled or failed mid-answer, and the config of the model that answered
+// it. ok is false if the provider didn't report usage.
+func (c *LLMClient) LastUsage() (config ModelConfig, usage Usage, ok bool) {
+	return c.lastConfig, c.lastUsage, c.lastUsage.TotalTokens > 0
+}
+
+// Trimmed reports what was left out of the last query to fit the model's
+// context window.
+func (c *LLMClient) Trimmed() Trimming {
+	return c.lastTrimmed
+}
+
+// ModelName is the name of the primary model.
+func (c *LLMClient) ModelName() string {
+	return c.routes[0].config.ModelName
+}
+
+// AnsweredBy is the name of the model that answered the last query, which
+// differs from ModelName when a fallback was used.
+func (c *LLMClient) AnsweredBy() string {
+	return c.answeredBy
+}
+
+// Describe names the service the client talks to, for error messages.
+func (c *LLMClient) Describe() string {
+	r := c.routes[0]
+	b, err := r.getBackend()
 	if err != nil {
-		return "", err
+		return r.config.ModelName
 	}
-	c.messages = append(c.messages, message)
-	return message.Content, nil
+	return b.describe(r.config)
 }
 
-func (c *LLMClient) processStream(resp *http.Response) (string, error) {
-	counter := 0
-	streamReader := bufio.NewReader(resp.Body)
-	totalData := ""
-	for {
-		line, err := streamReader.ReadString('\n')
-		if err != nil {
-			break
+func (c *LLMClient) callStream(ctx context.Context, r *route, messages []Message) (Message, Usage, error) {
+	if _, err := r.getBackend(); err != nil {
+		return Message{}, Usage{}, err
+	}
+	retry := retryConfig(r.config)
+	for attempt := 0; ; attempt++ {
+		message, usage, err := c.streamOnce(ctx, r, messages)
+		// don't retry once part of the answer has been shown
+		if err == nil || message.Content != "" || ctx.Err() != nil {
+			return message, usage, err
 		}
-		line = strings.TrimSpace(line)
-		if line == "data: [DONE]" {
-			break
+		reason, ok := retryReason(err)
+		if !ok || attempt >= retry.MaxRetries {
+			return message, usage, err
 		}
-		if strings.HasPrefix(line, "data:") {
-			payload := strings.TrimPrefix(line, "data:")
-
-			var responseData ResponseData
-			err = json.Unmarshal([]byte(payload), &responseData)
-			if err != nil {
-				fmt.Println("Error parsing data:", err)
-				continue
-			}
-			if len(responseData.Choices) == 0 {
-				continue
-			}
-			content := responseData.Choices[0].Delta.Content
-			if counter < 2 && strings.Count(content, "\n") > 0 {
-				continue
-			}
-			totalData += content
-			c.StreamCallback(totalData, nil)
-			counter++
+		wait, ok := backoff(retry, attempt, err)
+		if !ok {
+			return message, usage, err
+		}
+		if c.RetryCallback != nil {
+			c.RetryCallback(reason, wait)
+		}
+		select {
+		case <-ctx.Done():
+			return Message{}, Usage{}, ctx.Err()
+		case <-time.After(wait):
 		}
 	}
-	return totalData, nil
 }
 
-func (c *LLMClient) callStream(payload Payload) (Message, error) {
-	req, err := c.createRequest(payload)
+func (c *LLMClient) streamOnce(ctx context.Context, r *route, messages []Message) (Message, Usage, error) {
+	req, err := r.backend.createRequest(r.config, messages)
 	if err != nil {
-		return Message{}, fmt.Errorf("failed to create the request: %w", err)
+		return Message{}, Usage{}, fmt.Errorf("failed to create the request: %w", err)
 	}
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
 	if err != nil {
-		return Message{}, fmt.Errorf("failed to make the API request: %w", err)
+		return Message{}, Usage{}, fmt.Errorf("failed to make the API request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return Message{}, fmt.Errorf("API request failed: %s", resp.Status)
+		return Message{}, Usage{}, newAPIError(resp)
+	}
+
+	totalData := ""
+	usage, err := r.backend.processStream(resp.Body, func(content string) {
+		// skip leading newlines some models send before the answer
+		if totalData == "" {
+			content = strings.TrimLeft(content, "\n")
+		}
+		if content == "" {
+			return
+		}
+		totalData += content
+		if c.StreamCallback != nil {
+			c.StreamCallback(totalData, nil)
+		}
+	})
+	return Message{Role: "assistant", Content: totalData}, usage, err
+}
+
+// readSSE calls fn with the payload of every "data:" line of a server-sent
+// event stream, until the stream ends or fn reports it is done.
+func readSSE(body io.Reader, fn func(data string) (done bool, err error)) error {
+	streamReader := bufio.NewReader(body)
+	for {
+		line, err := streamReader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		done, err := fn(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+		if done || err != nil {
+			return err
+		}
+	}
+}
+
+// splitSystemPrompt pulls the system messages out of a conversation, for
+// providers that take the system prompt as a separate field.
+func splitSystemPrompt(messages []Message) (string, []Message) {
+	var system []string
+	var rest []Message
+	for _, m := range messages {
+		if m.Role == "system" {
+			system = append(system, m.Content)
+			continue
+		}
+		rest = append(rest, m)
+	}
+	return strings.Join(system, "\n\n"), rest
+}
+
+// mergeConsecutiveRoles joins back-to-back messages from the same role, since
+// some providers require user and assistant turns to alternate.
+func mergeConsecutiveRoles(messages []Message) []Message {
+	var merged []Message
+	for _, m := range messages {
+		if n := len(merged); n > 0 && merged[n-1].Role == m.Role {
+			merged[n-1].Content += "\n\n" + m.Content
+			continue
+		}
+		merged = append(merged, m)
 	}
-	content, err := c.processStream(resp)
-	return Message{Role: "assistant", Content: content}, err
+	return merged
 }
@@ -0,0 +1,186 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	. "q/types"
+	"strings"
+	"time"
+)
+
+const ollamaDefaultHost = "http://localhost:11434"
+
+// ollamaBackend speaks Ollama's native /api/chat format, which streams
+// newline delimited JSON instead of server-sent events.
+type ollamaBackend struct{}
+
+type ollamaPayload struct {
+	Model    string             `json:"model"`
+	Messages []Message          `json:"messages"`
+	Stream   bool               `json:"stream"`
+	Options  map[string]float32 `json:"options,omitempty"`
+}
+
+type ollamaChunk struct {
+	Message         Message `json:"message"`
+	Done            bool    `json:"done"`
+	Error           string  `json:"error"`
+	PromptEvalCount int     `json:"prompt_eval_count"`
+	EvalCount       int     `json:"eval_count"`
+}
+
+type OllamaModel struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+type OllamaPullProgress struct {
+	Status    string `json:"status"`
+	Total     int64  `json:"total"`
+	Completed int64  `json:"completed"`
+	Error     string `json:"error"`
+}
+
+// OllamaHost returns the base URL of the Ollama server, honoring $OLLAMA_HOST.
+func OllamaHost() string {
+	host := os.Getenv("OLLAMA_HOST")
+	if host == "" {
+		return ollamaDefaultHost
+	}
+	if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+		host = "http://" + host
+	}
+	return strings.TrimSuffix(host, "/")
+}
+
+// OllamaChatEndpoint is the endpoint to store in the config for Ollama models.
+func OllamaChatEndpoint(host string) string {
+	return host + "/api/chat"
+}
+
+func (ollamaBackend) createRequest(config ModelConfig, messages []Message) (*http.Request, error) {
+	payload := ollamaPayload{
+		Model:    config.ModelName,
+		Messages: messages,
+		Stream:   true,
+		Options:  map[string]float32{"temperature": 0},
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = OllamaChatEndpoint(OllamaHost())
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+func (ollamaBackend) processStream(body io.Reader, onContent func(string)) (Usage, error) {
+	var usage Usage
+	err := readNDJSON(body, func(line []byte) (bool, error) {
+		var chunk ollamaChunk
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			// skip lines that aren't chunks
+			return false, nil
+		}
+		if chunk.Error != "" {
+			return true, fmt.Errorf("stream error: %s", chunk.Error)
+		}
+		onContent(chunk.Message.Content)
+		if chunk.Done {
+			usage = Usage{
+				PromptTokens:     chunk.PromptEvalCount,
+				CompletionTokens: chunk.EvalCount,
+				TotalTokens:      chunk.PromptEvalCount + chunk.EvalCount,
+			}
+		}
+		return chunk.Done, nil
+	})
+	return usage, err
+}
+
+func (ollamaBackend) describe(config ModelConfig) string {
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = OllamaChatEndpoint(OllamaHost())
+	}
+	return "Ollama at " + strings.TrimSuffix(endpoint, "/api/chat")
+}
+
+// ListOllamaModels returns the models installed on the Ollama server.
+func ListOllamaModels(host string) ([]OllamaModel, error) {
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(host + "/api/tags")
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Ollama at %s: %w", host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("failed to list Ollama models: %s", resp.Status)
+	}
+	var tags struct {
+		Models []OllamaModel `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("failed to parse Ollama models: %w", err)
+	}
+	return tags.Models, nil
+}
+
+// PullOllamaModel downloads a model onto the Ollama server, reporting
+// progress as it goes.
+func PullOllamaModel(host, name string, onProgress func(OllamaPullProgress)) error {
+	payloadBytes, err := json.Marshal(map[string]interface{}{"name": name, "stream": true})
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	resp, err := http.Post(host+"/api/pull", "application/json", bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return fmt.Errorf("failed to reach Ollama at %s: %w", host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("failed to pull %s: %s", name, resp.Status)
+	}
+	return readNDJSON(resp.Body, func(line []byte) (bool, error) {
+		var progress OllamaPullProgress
+		if err := json.Unmarshal(line, &progress); err != nil {
+			return false, nil
+		}
+		if progress.Error != "" {
+			return true, fmt.Errorf("failed to pull %s: %s", name, progress.Error)
+		}
+		onProgress(progress)
+		return progress.Status == "success", nil
+	})
+}
+
+// readNDJSON calls fn with every non-empty line of a newline delimited JSON
+// stream, until the stream ends or fn reports it is done.
+func readNDJSON(body io.Reader, fn func(line []byte) (done bool, err error)) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		done, err := fn(line)
+		if done || err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
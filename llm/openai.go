@@ -0,0 +1,89 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	. "q/types"
+	"strings"
+)
+
+// openAIBackend speaks the OpenAI chat completions format, which most local
+// and third party servers also implement.
+type openAIBackend struct{}
+
+func (openAIBackend) createRequest(config ModelConfig, messages []Message) (*http.Request, error) {
+	payload := Payload{
+		Model:         config.ModelName,
+		Messages:      messages,
+		Temperature:   0,
+		Stream:        true,
+		StreamOptions: streamOptions(config, isOpenAIEndpoint(config.Endpoint)),
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	req, err := http.NewRequest("POST", config.Endpoint, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+config.Auth)
+	if config.OrgID != "" {
+		req.Header.Set("OpenAI-Organization", config.OrgID)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// streamOptions asks for token usage at the end of the stream. Servers that
+// don't know stream_options may reject the request, so unless the model's
+// stream_usage says otherwise it's only sent where it's supported.
+func streamOptions(config ModelConfig, supported bool) *StreamOptions {
+	if config.StreamUsage != nil {
+		supported = *config.StreamUsage
+	}
+	if !supported {
+		return nil
+	}
+	return &StreamOptions{IncludeUsage: true}
+}
+
+func isOpenAIEndpoint(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	return err == nil && u.Hostname() == "api.openai.com"
+}
+
+func (openAIBackend) processStream(body io.Reader, onContent func(string)) (Usage, error) {
+	var usage Usage
+	err := readSSE(body, func(data string) (bool, error) {
+		if data == "[DONE]" {
+			return true, nil
+		}
+		var responseData ResponseData
+		err := json.Unmarshal([]byte(data), &responseData)
+		if err != nil {
+			// skip data that isn't an event
+			return false, nil
+		}
+		if responseData.Usage.TotalTokens > 0 {
+			usage = responseData.Usage
+		}
+		if len(responseData.Choices) == 0 {
+			return false, nil
+		}
+		onContent(responseData.Choices[0].Delta.Content)
+		return false, nil
+	})
+	return usage, err
+}
+
+func (openAIBackend) describe(config ModelConfig) string {
+	if config.Endpoint == "" || strings.Contains(config.Endpoint, "api.openai.com") {
+		return "OpenAI"
+	}
+	return config.Endpoint
+}
@@ -0,0 +1,100 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	. "q/types"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultMaxRetries     = 3
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = 30 * time.Second
+)
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as
+// an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// retryPolicy is a model's retry config with the defaults filled in.
+type retryPolicy struct {
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func retryConfig(config ModelConfig) retryPolicy {
+	retry := retryPolicy{
+		MaxRetries:     defaultMaxRetries,
+		InitialBackoff: defaultInitialBackoff,
+		MaxBackoff:     defaultMaxBackoff,
+	}
+	if config.Retry == nil {
+		return retry
+	}
+	if config.Retry.MaxRetries != nil {
+		retry.MaxRetries = *config.Retry.MaxRetries
+	}
+	if config.Retry.InitialBackoff > 0 {
+		retry.InitialBackoff = config.Retry.InitialBackoff
+	}
+	if config.Retry.MaxBackoff > 0 {
+		retry.MaxBackoff = config.Retry.MaxBackoff
+	}
+	return retry
+}
+
+// retryReason reports whether err is worth retrying, and why in a few words.
+func retryReason(err error) (string, bool) {
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests:
+		// some providers send 429 when the quota is used up, which won't
+		// come back by waiting
+		if classifyAPIError(apiErr) == ErrorQuota {
+			return "", false
+		}
+		return "rate limited", true
+	case errors.As(err, &apiErr) && apiErr.StatusCode >= 500:
+		return fmt.Sprintf("server error (%d)", apiErr.StatusCode), true
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection reset", true
+	}
+	return "", false
+}
+
+// backoff returns how long to wait before the given retry (starting at 0):
+// exponential from the initial backoff, capped, with jitter so parallel
+// clients don't retry in lockstep. Retry-After wins when the server sends it,
+// but if it asks to wait longer than the cap it's not worth waiting, and ok
+// is false.
+func backoff(retry retryPolicy, attempt int, err error) (wait time.Duration, ok bool) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter, apiErr.RetryAfter <= retry.MaxBackoff
+	}
+	wait = retry.InitialBackoff << uint(attempt)
+	if wait <= 0 || wait > retry.MaxBackoff {
+		wait = retry.MaxBackoff
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1)), true
+}
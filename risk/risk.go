@@ -0,0 +1,656 @@
+package risk
+
+import (
+	"path"
+	"strings"
+)
+
+type Level int
+
+const (
+	None Level = iota
+	Low
+	Medium
+	High
+)
+
+func (l Level) String() string {
+	switch l {
+	case Low:
+		return "low"
+	case Medium:
+		return "medium"
+	case High:
+		return "high"
+	}
+	return "none"
+}
+
+type Category string
+
+const (
+	Destructive         Category = "destructive"
+	PrivilegeEscalation Category = "privilege escalation"
+	NetworkPipe         Category = "network piping"
+	Irreversible        Category = "irreversible"
+)
+
+type Finding struct {
+	Level    Level
+	Category Category
+	Command  string
+	Reason   string
+}
+
+type Report struct {
+	Level    Level
+	Findings []Finding
+}
+
+func (r *Report) add(f Finding) {
+	if f.Level > r.Level {
+		r.Level = f.Level
+	}
+	r.Findings = append(r.Findings, f)
+}
+
+// Analyze parses a shell snippet and classifies the operations in it that
+// deserve a second look before they are copied or executed.
+func Analyze(snippet string) Report {
+	report := Report{}
+	if strings.Contains(strings.ReplaceAll(snippet, " ", ""), ":(){:|:&};:") {
+		report.add(Finding{High, Destructive, ":(){ :|:& };:", "fork bomb"})
+	}
+	upper := strings.ToUpper(snippet)
+	for _, stmt := range []string{"DROP TABLE", "DROP DATABASE", "TRUNCATE TABLE"} {
+		if strings.Contains(upper, stmt) {
+			report.add(Finding{High, Irreversible, stmt, "drops data from a database"})
+		}
+	}
+	for _, pipeline := range parse(snippet) {
+		analyzePipeline(&report, pipeline)
+	}
+	return report
+}
+
+// === Parsing === //
+
+type token struct {
+	text string
+	op   bool
+}
+
+type simpleCommand struct {
+	args      []string
+	redirects []string
+}
+
+func (c simpleCommand) String() string {
+	return strings.Join(c.args, " ")
+}
+
+type pipeline []simpleCommand
+
+// tokenize splits a snippet into words and operators, honoring quotes and
+// backslash escapes. Comments run to the end of the line.
+func tokenize(s string) []token {
+	var tokens []token
+	var word strings.Builder
+	inWord := false
+	flush := func() {
+		if inWord {
+			tokens = append(tokens, token{text: word.String()})
+			word.Reset()
+			inWord = false
+		}
+	}
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\' && i+1 < len(runes):
+			i++
+			if runes[i] != '\n' {
+				word.WriteRune(runes[i])
+				inWord = true
+			}
+		case r == '\'' || r == '"':
+			inWord = true
+			for i++; i < len(runes) && runes[i] != r; i++ {
+				if r == '"' && runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				word.WriteRune(runes[i])
+			}
+		case r == '#' && !inWord:
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			i--
+		case r == ' ' || r == '\t':
+			flush()
+		case r == '\n' || r == ';':
+			flush()
+			tokens = append(tokens, token{text: ";", op: true})
+		case r == '&' && i+1 < len(runes) && runes[i+1] == '>':
+			// &> and &>> redirect both stdout and stderr
+			flush()
+			op := "&"
+			for i+1 < len(runes) && runes[i+1] == '>' {
+				i++
+				op += ">"
+			}
+			tokens = append(tokens, token{text: op, op: true})
+		case r == '|' || r == '&':
+			flush()
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == r {
+				op += string(r)
+				i++
+			}
+			tokens = append(tokens, token{text: op, op: true})
+		case (r == '$' || r == '<' || r == '>') && i+1 < len(runes) && runes[i+1] == '(':
+			// command and process substitutions are kept whole, their
+			// commands are analyzed separately
+			if r != '$' {
+				flush()
+			}
+			end := closingParen(runes, i+1)
+			word.WriteString(string(runes[i:end]))
+			inWord = true
+			i = end - 1
+		case r == '`':
+			end := i + 1
+			for end < len(runes) && runes[end] != '`' {
+				end++
+			}
+			if end < len(runes) {
+				end++
+			}
+			word.WriteString(string(runes[i:end]))
+			inWord = true
+			i = end - 1
+		case r == '>' || r == '<':
+			// keep a leading fd number (2>) and a trailing fd dup (>&1) with the operator
+			op := ""
+			if inWord && isDigits(word.String()) {
+				op = word.String()
+				word.Reset()
+				inWord = false
+			}
+			flush()
+			op += string(r)
+			for i+1 < len(runes) && (runes[i+1] == '>' || runes[i+1] == '&') {
+				i++
+				op += string(runes[i])
+			}
+			tokens = append(tokens, token{text: op, op: true})
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	flush()
+	return tokens
+}
+
+func parse(s string) []pipeline {
+	var pipelines []pipeline
+	var current pipeline
+	var cmd simpleCommand
+	endCommand := func() {
+		if len(cmd.args) > 0 || len(cmd.redirects) > 0 {
+			current = append(current, cmd)
+		}
+		cmd = simpleCommand{}
+	}
+	endPipeline := func() {
+		endCommand()
+		if len(current) > 0 {
+			pipelines = append(pipelines, current)
+		}
+		current = nil
+	}
+	tokens := tokenize(s)
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if !t.op {
+			cmd.args = append(cmd.args, t.text)
+			continue
+		}
+		switch {
+		case t.text == "|":
+			endCommand()
+		case t.text == ";" || t.text == "&&" || t.text == "||" || t.text == "&":
+			endPipeline()
+		case strings.ContainsAny(t.text, "<>"):
+			target := ""
+			if i+1 < len(tokens) && !tokens[i+1].op {
+				target = tokens[i+1].text
+				i++
+			}
+			// >&2 and >&- duplicate or close a descriptor, while >& file
+			// redirects stdout and stderr to the file
+			dup := strings.HasSuffix(t.text, "&") && (isDigits(target) || target == "-")
+			if strings.Contains(t.text, ">") && target != "" && !dup {
+				cmd.redirects = append(cmd.redirects, target)
+			}
+		}
+	}
+	endPipeline()
+	return pipelines
+}
+
+// closingParen returns the index just past the parenthesis closing the one
+// at runes[open], skipping quoted text, or len(runes) if it's unclosed.
+func closingParen(runes []rune, open int) int {
+	depth := 0
+	for i := open; i < len(runes); i++ {
+		switch runes[i] {
+		case '\'', '"':
+			quote := runes[i]
+			for i++; i < len(runes) && runes[i] != quote; i++ {
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(runes)
+}
+
+// substitutions returns the commands inside the $(...), <(...), >(...) and
+// backtick substitutions in a word.
+func substitutions(word string) []string {
+	var commands []string
+	runes := []rune(word)
+	for i := 0; i < len(runes); i++ {
+		switch {
+		case (runes[i] == '$' || runes[i] == '<' || runes[i] == '>') && i+1 < len(runes) && runes[i+1] == '(':
+			end := closingParen(runes, i+1)
+			inner := runes[i+2 : end]
+			if len(inner) > 0 && inner[len(inner)-1] == ')' {
+				inner = inner[:len(inner)-1]
+			}
+			commands = append(commands, string(inner))
+			i = end - 1
+		case runes[i] == '`':
+			end := i + 1
+			for end < len(runes) && runes[end] != '`' {
+				end++
+			}
+			commands = append(commands, string(runes[i+1:end]))
+			i = end
+		}
+	}
+	return commands
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// === Rules === //
+
+var shells = map[string]bool{
+	"sh": true, "bash": true, "zsh": true, "fish": true, "dash": true, "ksh": true,
+	"source": true, ".": true, "eval": true,
+	"python": true, "python3": true, "perl": true, "ruby": true, "node": true,
+}
+
+var downloaders = map[string]bool{
+	"curl": true, "wget": true, "fetch": true, "iwr": true, "Invoke-WebRequest": true,
+}
+
+var elevators = map[string]bool{
+	"sudo": true, "doas": true, "su": true, "pkexec": true, "runas": true,
+}
+
+// wrappers run the rest of their arguments as a command.
+var wrappers = map[string]bool{
+	"env": true, "nohup": true, "time": true, "nice": true, "command": true, "exec": true, "xargs": true,
+}
+
+func analyzePipeline(report *Report, p pipeline) {
+	downloading := false
+	for _, cmd := range p {
+		for _, arg := range cmd.args {
+			for _, inner := range substitutions(arg) {
+				for _, pipeline := range parse(inner) {
+					analyzePipeline(report, pipeline)
+				}
+			}
+		}
+		args := unwrap(report, cmd)
+		if len(args) == 0 {
+			continue
+		}
+		name := path.Base(args[0])
+		if downloading && shells[name] && readsScriptFromStdin(name, args[1:]) {
+			report.add(Finding{High, NetworkPipe, cmd.String(), "pipes downloaded content straight into " + name})
+		}
+		if downloaders[name] {
+			downloading = true
+		}
+		if shells[name] {
+			for _, arg := range args[1:] {
+				if runsDownload(arg) {
+					report.add(Finding{High, NetworkPipe, cmd.String(), "runs downloaded content with " + name})
+					break
+				}
+			}
+		}
+		analyzeRedirects(report, cmd)
+		analyzeCommand(report, name, args, cmd.String())
+	}
+}
+
+// unwrap strips env assignments and wrapper commands, recording privilege
+// escalation along the way, and returns the arguments of the real command.
+func unwrap(report *Report, cmd simpleCommand) []string {
+	args := cmd.args
+	for len(args) > 0 {
+		name := path.Base(args[0])
+		switch {
+		case strings.Contains(args[0], "=") && !strings.HasPrefix(args[0], "-"):
+			args = args[1:]
+		case elevators[name]:
+			report.add(Finding{Medium, PrivilegeEscalation, cmd.String(), "runs with elevated privileges (" + name + ")"})
+			args = skipFlags(args[1:])
+		case wrappers[name]:
+			args = skipFlags(args[1:])
+		default:
+			return args
+		}
+	}
+	return args
+}
+
+func skipFlags(args []string) []string {
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		args = args[1:]
+	}
+	return args
+}
+
+// codeFlags are the short flags that give an interpreter its code on the
+// command line. Shells take it with -c.
+var codeFlags = map[string]string{
+	"python": "cm", "python3": "cm", "node": "ep", "ruby": "e", "perl": "eE",
+}
+
+// readsScriptFromStdin reports whether a shell or interpreter, given these
+// arguments, runs what's piped into it rather than a script file or code
+// passed on the command line (like python3 -m json.tool).
+func readsScriptFromStdin(name string, args []string) bool {
+	flags, ok := codeFlags[name]
+	if !ok {
+		flags = "c"
+	}
+	for _, arg := range args {
+		switch {
+		case arg == "-" || arg == "/dev/stdin":
+			return true
+		case arg == "--":
+			continue
+		case arg == "--eval" || arg == "--print" || arg == "--command":
+			return false
+		case strings.HasPrefix(arg, "--"):
+			continue
+		case strings.HasPrefix(arg, "-"):
+			if strings.ContainsAny(arg[1:], flags) {
+				return false
+			}
+		default:
+			// a script file
+			return false
+		}
+	}
+	return true
+}
+
+// runsDownload reports whether a snippet (like the argument of sh -c) runs a
+// downloader, directly or in a substitution.
+func runsDownload(snippet string) bool {
+	for _, p := range parse(snippet) {
+		for _, cmd := range p {
+			if args := unwrap(&Report{}, cmd); len(args) > 0 && downloaders[path.Base(args[0])] {
+				return true
+			}
+			for _, arg := range cmd.args {
+				for _, inner := range substitutions(arg) {
+					if runsDownload(inner) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+func analyzeRedirects(report *Report, cmd simpleCommand) {
+	for _, target := range cmd.redirects {
+		switch {
+		case isBlockDevice(target):
+			report.add(Finding{High, Destructive, cmd.String(), "overwrites the block device " + target})
+		case strings.HasPrefix(target, "/etc/") || strings.HasPrefix(target, "/boot/"):
+			report.add(Finding{Medium, Destructive, cmd.String(), "overwrites the system file " + target})
+		}
+	}
+}
+
+func analyzeCommand(report *Report, name string, args []string, text string) {
+	flags, operands := splitArgs(args[1:])
+	// mkfs.ext4, mkfs.vfat...
+	if strings.HasPrefix(name, "mkfs.") {
+		name = "mkfs"
+	}
+	switch name {
+	case "rm":
+		recursive := flags.has('r', "recursive") || flags.has('R', "")
+		force := flags.has('f', "force")
+		switch {
+		case recursive && anyCritical(operands):
+			report.add(Finding{High, Destructive, text, "recursively deletes " + strings.Join(operands, " ")})
+		case recursive && force:
+			report.add(Finding{Medium, Destructive, text, "recursively force-deletes files"})
+		case recursive:
+			report.add(Finding{Medium, Destructive, text, "recursively deletes files"})
+		default:
+			report.add(Finding{Low, Destructive, text, "deletes files"})
+		}
+	case "dd":
+		for _, op := range operands {
+			if strings.HasPrefix(op, "of=") && isBlockDevice(strings.TrimPrefix(op, "of=")) {
+				report.add(Finding{High, Destructive, text, "writes directly to " + strings.TrimPrefix(op, "of=")})
+			}
+		}
+	case "mkfs", "fdisk", "sfdisk", "parted", "wipefs", "shred", "diskutil", "format":
+		report.add(Finding{High, Destructive, text, "erases or repartitions a disk"})
+	case "chmod", "chown", "chgrp":
+		recursive := flags.has('R', "recursive")
+		switch {
+		case recursive && anyCritical(operands):
+			report.add(Finding{High, Destructive, text, "recursively changes permissions on " + strings.Join(operands[1:], " ")})
+		case name == "chmod" && contains(operands, "777"):
+			report.add(Finding{Medium, Destructive, text, "makes files world-writable"})
+		case recursive:
+			report.add(Finding{Low, Destructive, text, "recursively changes permissions"})
+		}
+	case "git":
+		flags, operands := splitArgs(skipGitOptions(args[1:]))
+		analyzeGit(report, flags, operands, text)
+	case "find":
+		if contains(args, "-delete") || (contains(args, "-exec") && contains(args, "rm")) {
+			report.add(Finding{Medium, Destructive, text, "deletes every file it finds"})
+		}
+	case "kill", "killall", "pkill":
+		if contains(operands, "-1") {
+			report.add(Finding{High, Destructive, text, "kills every process you own"})
+		}
+	case "shutdown", "reboot", "halt", "poweroff":
+		report.add(Finding{Medium, Destructive, text, "shuts down or restarts the machine"})
+	case "crontab":
+		if flags.has('r', "") {
+			report.add(Finding{High, Irreversible, text, "deletes your crontab without a backup"})
+		}
+	case "mv":
+		if len(operands) > 0 && operands[len(operands)-1] == "/dev/null" {
+			report.add(Finding{High, Irreversible, text, "moves files into /dev/null"})
+		}
+	case "truncate":
+		report.add(Finding{Medium, Irreversible, text, "truncates file contents"})
+	case "terraform":
+		if contains(operands, "destroy") {
+			report.add(Finding{High, Irreversible, text, "destroys managed infrastructure"})
+		}
+	case "kubectl":
+		if contains(operands, "delete") {
+			report.add(Finding{Medium, Destructive, text, "deletes cluster resources"})
+		}
+	case "docker", "podman":
+		if contains(operands, "prune") || (contains(operands, "rm") && flags.has('f', "force")) {
+			report.add(Finding{Medium, Destructive, text, "removes containers, images or volumes"})
+		}
+	}
+}
+
+// gitOptionsWithValue are git's global options that take a separate value.
+var gitOptionsWithValue = map[string]bool{
+	"-C": true, "-c": true, "--git-dir": true, "--work-tree": true, "--namespace": true, "--config-env": true,
+}
+
+// skipGitOptions strips git's global options (like -C repo), which come
+// before the subcommand.
+func skipGitOptions(args []string) []string {
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if gitOptionsWithValue[args[0]] && len(args) > 1 {
+			args = args[2:]
+			continue
+		}
+		args = args[1:]
+	}
+	return args
+}
+
+func analyzeGit(report *Report, flags flagSet, operands []string, text string) {
+	if len(operands) == 0 {
+		return
+	}
+	switch operands[0] {
+	case "push":
+		switch {
+		case flags.has('f', "force") || anyHasPrefix(operands, "+"):
+			report.add(Finding{High, Irreversible, text, "force-pushes, overwriting remote history"})
+		case flags.has(0, "force-with-lease"):
+			report.add(Finding{Medium, Irreversible, text, "force-pushes with lease"})
+		case flags.has(0, "delete") || flags.has('d', ""):
+			report.add(Finding{Medium, Irreversible, text, "deletes a remote branch"})
+		}
+	case "reset":
+		if flags.has(0, "hard") {
+			report.add(Finding{Medium, Irreversible, text, "discards uncommitted changes"})
+		}
+	case "clean":
+		if flags.has('f', "force") {
+			report.add(Finding{Medium, Destructive, text, "deletes untracked files"})
+		}
+	case "checkout", "restore":
+		if contains(operands, ".") {
+			report.add(Finding{Low, Irreversible, text, "discards local modifications"})
+		}
+	case "branch":
+		if flags.has('D', "") {
+			report.add(Finding{Medium, Irreversible, text, "deletes a branch even if unmerged"})
+		}
+	}
+}
+
+type flagSet struct {
+	short string
+	long  []string
+}
+
+func (f flagSet) has(short rune, long string) bool {
+	if short != 0 && strings.ContainsRune(f.short, short) {
+		return true
+	}
+	return long != "" && contains(f.long, long)
+}
+
+func splitArgs(args []string) (flagSet, []string) {
+	flags := flagSet{}
+	var operands []string
+	for i, arg := range args {
+		switch {
+		case arg == "--":
+			return flags, append(operands, args[i+1:]...)
+		case strings.HasPrefix(arg, "--"):
+			flags.long = append(flags.long, strings.SplitN(arg[2:], "=", 2)[0])
+		case strings.HasPrefix(arg, "-") && len(arg) > 1 && !isDigits(arg[1:]):
+			flags.short += arg[1:]
+		default:
+			operands = append(operands, arg)
+		}
+	}
+	return flags, operands
+}
+
+// isCritical reports whether a path is the root of something important enough
+// that recursive operations on it are almost certainly a mistake.
+func isCritical(p string) bool {
+	switch strings.TrimSuffix(p, "/") {
+	case "", "/*", "~", "$HOME", "${HOME}", "*", ".", "..", "/usr", "/etc", "/var", "/bin", "/boot", "/home", "/Users", "/System", "C:", "C:\\":
+		return true
+	}
+	return false
+}
+
+func anyCritical(paths []string) bool {
+	for _, p := range paths {
+		if isCritical(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func isBlockDevice(p string) bool {
+	for _, prefix := range []string{"/dev/sd", "/dev/hd", "/dev/nvme", "/dev/disk", "/dev/mmcblk", "/dev/vd", "/dev/xvd"} {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func anyHasPrefix(list []string, prefix string) bool {
+	for _, item := range list {
+		if strings.HasPrefix(item, prefix) {
+			return true
+		}
+	}
+	return false
+}
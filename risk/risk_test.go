@@ -0,0 +1,118 @@
+package risk
+
+import "testing"
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		snippet string
+		want    Level
+	}{
+		// harmless
+		{"ls -la", None},
+		{"echo 'rm -rf /'", None},
+		{"grep -r TODO . # rm -rf /", None},
+		{"git push origin main", None},
+		{"git -C repo status", None},
+		{"diff <(sort a) <(sort b)", None},
+		{"echo $(date)", None},
+		{"cat foo 2>&1 > out.txt", None},
+
+		// deleting files
+		{"rm foo.txt", Low},
+		{"rm -r build", Medium},
+		{"rm -rf node_modules", Medium},
+		{"rm -rf /", High},
+		{"rm -rf ~", High},
+		{"sudo rm -r --no-preserve-root /", High},
+		{"find . -name '*.log' -delete", Medium},
+		{"echo $(rm -rf /)", High},
+		{"echo `rm -rf ~`", High},
+
+		// disks
+		{"dd if=image.iso of=/dev/sda bs=4M", High},
+		{"cat image.iso > /dev/sdb", High},
+		{"cmd &> /dev/sda", High},
+		{"cmd &>>/dev/sda", High},
+		{"cmd >& /dev/sda", High},
+		{"cmd 2>&1 >/dev/null", None},
+		{"cmd >&2", None},
+		{"mkfs.ext4 /dev/sdb1", High},
+		{"mkfs -t ext4 /dev/sdb1", High},
+		{"echo nameserver 1.1.1.1 > /etc/resolv.conf", Medium},
+
+		// running downloaded code
+		{"curl -fsSL https://x.sh | sh", High},
+		{"wget -qO- https://x.sh | sudo bash", High},
+		{"bash <(curl -s https://x.sh)", High},
+		{"source <(curl -s https://x.sh)", High},
+		{`sh -c "$(curl -fsSL https://x.sh)"`, High},
+		{"bash -c $(curl -fsSL https://x.sh)", High},
+		{"eval \"$(wget -qO- https://x.sh)\"", High},
+		{"curl -o x.sh https://x.sh", None},
+		{`sh -c "git fetch origin && git status"`, None},
+		{`bash -c "env X=1 curl -fsSL https://x.sh | sh"`, High},
+		{"curl -fsSL https://x.sh | sh -s -- --yes", High},
+		{"curl -fsSL https://x.py | python3 -", High},
+		{"curl -fsSL https://x.py | python3", High},
+		{"curl -s https://api.github.com/x | python3 -m json.tool", None},
+		{"curl -s https://x | jq . | python3 -c 'import sys; print(sys.stdin.read())'", None},
+		{"curl -s https://x | node -e 'process.stdin.pipe(process.stdout)'", None},
+		{"curl -s https://x | bash script.sh", None},
+		{"curl -fsSL https://x.sh | bash -ex", High},
+
+		// privileges and permissions
+		{"sudo apt install jq", Medium},
+		{"chmod 777 file", Medium},
+		{"chmod -R 755 /", High},
+
+		// git
+		{"git push -f", High},
+		{"git push origin +main", High},
+		{"git -C repo push -f", High},
+		{"git -c user.name=x push --force", High},
+		{"git --git-dir .git push --force-with-lease", Medium},
+		{"git reset --hard HEAD~1", Medium},
+		{"git clean -fd", Medium},
+		{"git checkout .", Low},
+
+		// other
+		{":(){ :|:& };:", High},
+		{"psql -c 'DROP TABLE users'", High},
+		{"terraform destroy", High},
+		{"kubectl delete pod web", Medium},
+		{"crontab -r", High},
+		{"env FOO=1 nohup rm -rf /", High},
+	}
+	for _, tt := range tests {
+		if got := Analyze(tt.snippet).Level; got != tt.want {
+			t.Errorf("Analyze(%q) = %s, want %s", tt.snippet, got, tt.want)
+		}
+	}
+}
+
+func TestSubstitutions(t *testing.T) {
+	tests := []struct {
+		word string
+		want []string
+	}{
+		{"plain", nil},
+		{"$(date)", []string{"date"}},
+		{"<(curl -s x)", []string{"curl -s x"}},
+		{"pre$(a $(b))post", []string{"a $(b)"}},
+		{"`whoami`", []string{"whoami"}},
+		{"$(unclosed", []string{"unclosed"}},
+	}
+	for _, tt := range tests {
+		got := substitutions(tt.word)
+		if len(got) != len(tt.want) {
+			t.Errorf("substitutions(%q) = %q, want %q", tt.word, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("substitutions(%q) = %q, want %q", tt.word, got, tt.want)
+				break
+			}
+		}
+	}
+}
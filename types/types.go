@@ -1,11 +1,59 @@
 package types
 
+import "time"
+
 type ModelConfig struct {
-	ModelName string    `yaml:"name"`
-	Endpoint  string    `yaml:"endpoint"`
-	Auth      string    `yaml:"auth_env_var"`
-	OrgID     string    `yaml:"org_env_var,omitempty"`
-	Prompt    []Message `yaml:"prompt"`
+	ModelName string   `yaml:"name"`
+	Aliases   []string `yaml:"aliases,omitempty"`
+	Provider  string   `yaml:"provider,omitempty"`
+	Endpoint  string   `yaml:"endpoint"`
+	Auth      string   `yaml:"auth_env_var"`
+	OrgID     string   `yaml:"org_env_var,omitempty"`
+	MaxTokens int      `yaml:"max_tokens,omitempty"`
+	// ContextWindow is how many tokens the model can take in, counting the
+	// answer. Older messages are trimmed to fit it.
+	ContextWindow int          `yaml:"context_window,omitempty"`
+	Retry         *RetryConfig `yaml:"retry,omitempty"`
+	Fallback      []string     `yaml:"fallback,omitempty"`
+	Pricing       *Pricing     `yaml:"pricing,omitempty"`
+	Budget        *Budget      `yaml:"budget,omitempty"`
+	StreamUsage   *bool        `yaml:"stream_usage,omitempty"`
+	Prompt        []Message    `yaml:"prompt"`
+
+	// Azure OpenAI
+	Resource     string `yaml:"resource,omitempty"`
+	Deployment   string `yaml:"deployment,omitempty"`
+	APIVersion   string `yaml:"api_version,omitempty"`
+	TokenCommand string `yaml:"token_command,omitempty"`
+}
+
+// Mode is a named prompt that replaces the model's own, so one prompt can be
+// used with any model.
+type Mode struct {
+	Name        string    `yaml:"name"`
+	Description string    `yaml:"description,omitempty"`
+	Prompt      []Message `yaml:"prompt"`
+}
+
+type RetryConfig struct {
+	// MaxRetries is a pointer so that 0 (never retry) differs from unset.
+	MaxRetries     *int          `yaml:"max_retries,omitempty"`
+	InitialBackoff time.Duration `yaml:"initial_backoff,omitempty"`
+	MaxBackoff     time.Duration `yaml:"max_backoff,omitempty"`
+}
+
+// Pricing is what a model costs, in dollars per million tokens.
+type Pricing struct {
+	InputPerMillion  float64 `yaml:"input_per_million"`
+	OutputPerMillion float64 `yaml:"output_per_million"`
+}
+
+// Budget caps spending in dollars, priced with Pricing. A zero cap is unlimited.
+type Budget struct {
+	Daily   float64 `yaml:"daily,omitempty"`
+	Monthly float64 `yaml:"monthly,omitempty"`
+	// WarnAt is the fraction of a cap after which q warns, 0.8 by default.
+	WarnAt float64 `yaml:"warn_at,omitempty"`
 }
 
 type Message struct {
@@ -14,16 +62,54 @@ type Message struct {
 }
 
 type Preferences struct {
-	DefaultModel string `yaml:"default_model"`
+	DefaultModel  string   `yaml:"default_model"`
+	MaxStdinBytes int      `yaml:"max_stdin_bytes,omitempty"`
+	Fallback      []string `yaml:"fallback,omitempty"`
+	// Budget caps the spending across all models.
+	Budget *Budget `yaml:"budget,omitempty"`
+	// Environment tunes the description of the user's machine that is added
+	// to the system message.
+	Environment *EnvironmentConfig `yaml:"environment,omitempty"`
+}
+
+// ContextProvider attaches the output of a command, a file or an env var
+// to queries. Set one of Command, File or Env.
+type ContextProvider struct {
+	Name    string `yaml:"name"`
+	Command string `yaml:"command,omitempty"`
+	File    string `yaml:"file,omitempty"`
+	Env     string `yaml:"env,omitempty"`
+	// Always attaches it to every query, not only when picked with --context.
+	Always   bool          `yaml:"always,omitempty"`
+	Timeout  time.Duration `yaml:"timeout,omitempty"`
+	MaxBytes int           `yaml:"max_bytes,omitempty"`
+}
+
+type EnvironmentConfig struct {
+	// Exclude lists the items to leave out: os, shell, cwd, tools, or all.
+	Exclude []string `yaml:"exclude,omitempty"`
+	// Tools replaces the list of tools to check for.
+	Tools []string `yaml:"tools,omitempty"`
 }
 
 type Payload struct {
-	Model       string    `json:"model"`
-	Prompt      string    `json:"prompt,omitempty"`
-	MaxTokens   int       `json:"max_tokens,omitempty"`
-	Temperature float32   `json:"temperature,omitempty"`
-	Messages    []Message `json:"messages"`
-	Stream      bool      `json:"stream,omitempty"`
+	Model         string         `json:"model"`
+	Prompt        string         `json:"prompt,omitempty"`
+	MaxTokens     int            `json:"max_tokens,omitempty"`
+	Temperature   float32        `json:"temperature,omitempty"`
+	Messages      []Message      `json:"messages"`
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 type ResponseData struct {
@@ -31,11 +117,7 @@ type ResponseData struct {
 	Object  string `json:"object"`
 	Created int    `json:"created"`
 	Model   string `json:"model"`
-	Usage   struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	} `json:"usage"`
+	Usage   Usage  `json:"usage"`
 	Choices []struct {
 		Delta struct {
 			Content string `json:"content"`
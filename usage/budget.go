@@ -0,0 +1,138 @@
+package usage
+
+import (
+	"fmt"
+	. "q/types"
+	"time"
+)
+
+const defaultWarnAt = 0.8
+
+// BudgetError is returned when a query would go over a spending cap.
+type BudgetError struct {
+	// Model is empty for the global budget.
+	Model  string
+	Period string
+	Spent  float64
+	Cap    float64
+}
+
+func (e *BudgetError) Error() string {
+	scope := e.Period + " budget"
+	if e.Model != "" {
+		scope += " for " + e.Model
+	}
+	return fmt.Sprintf("%s reached (%s of %s spent)", scope, FormatCost(e.Spent), FormatCost(e.Cap))
+}
+
+// Ledger is the usage ledger loaded into memory, checked against the
+// budgets before each query.
+type Ledger struct {
+	records []Record
+	global  *Budget
+}
+
+// OpenLedger loads the ledger. global is the budget shared by all models,
+// or nil.
+func OpenLedger(global *Budget) (*Ledger, error) {
+	records, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Ledger{records: records, global: global}, nil
+}
+
+// Add records a query, in memory and in the ledger file.
+func (l *Ledger) Add(record Record) error {
+	l.records = append(l.records, record)
+	return Append(record)
+}
+
+// Check returns a *BudgetError if the model, or all models together, have
+// spent their daily or monthly budget.
+func (l *Ledger) Check(modelConfig ModelConfig) error {
+	for _, limit := range l.limits(modelConfig, time.Now()) {
+		if limit.Spent >= limit.Cap {
+			err := limit
+			return &err
+		}
+	}
+	return nil
+}
+
+// Warnings describes the budgets the model is close to spending.
+func (l *Ledger) Warnings(modelConfig ModelConfig) []string {
+	var warnings []string
+	for _, limit := range l.limits(modelConfig, time.Now()) {
+		if limit.Spent < limit.Cap*warnAt(l.budgetFor(limit.Model, modelConfig)) {
+			continue
+		}
+		scope := limit.Period + " budget"
+		if limit.Model != "" {
+			scope += " for " + limit.Model
+		}
+		warnings = append(warnings, fmt.Sprintf("%s of the %s %s spent (%.0f%%).",
+			FormatCost(limit.Spent), FormatCost(limit.Cap), scope, 100*limit.Spent/limit.Cap))
+	}
+	return warnings
+}
+
+// Unpriced warns when a budget applies to the model but the model has no
+// pricing, so its spending is never counted against it.
+func (l *Ledger) Unpriced(modelConfig ModelConfig) string {
+	if modelConfig.Pricing != nil || (!hasCap(l.global) && !hasCap(modelConfig.Budget)) {
+		return ""
+	}
+	return fmt.Sprintf("%s has no pricing, so the budget can't count what it spends. Add pricing to it in ~/.shell-ai/config.yaml.", modelConfig.ModelName)
+}
+
+func hasCap(budget *Budget) bool {
+	return budget != nil && (budget.Daily > 0 || budget.Monthly > 0)
+}
+
+func (l *Ledger) budgetFor(model string, modelConfig ModelConfig) *Budget {
+	if model == "" {
+		return l.global
+	}
+	return modelConfig.Budget
+}
+
+// limits lists every cap that applies to the model, with what has been
+// spent against it so far.
+func (l *Ledger) limits(modelConfig ModelConfig, now time.Time) []BudgetError {
+	var limits []BudgetError
+	add := func(model string, budget *Budget) {
+		if budget == nil {
+			return
+		}
+		periods := []struct {
+			name  string
+			cap   float64
+			start time.Time
+		}{
+			{"daily", budget.Daily, StartOfDay(now)},
+			{"monthly", budget.Monthly, StartOfMonth(now)},
+		}
+		for _, period := range periods {
+			if period.cap <= 0 {
+				continue
+			}
+			summary := Since(l.records, period.start)
+			spent := summary.Total.Cost
+			if model != "" {
+				spent = summary.ByModel[model].Cost
+			}
+			limits = append(limits, BudgetError{Model: model, Period: period.name, Spent: spent, Cap: period.cap})
+		}
+	}
+	add("", l.global)
+	add(modelConfig.ModelName, modelConfig.Budget)
+	return limits
+}
+
+func warnAt(budget *Budget) float64 {
+	if budget == nil || budget.WarnAt <= 0 {
+		return defaultWarnAt
+	}
+	return budget.WarnAt
+}
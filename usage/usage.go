@@ -0,0 +1,172 @@
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"q/config"
+	. "q/types"
+	"sort"
+	"time"
+)
+
+var ledgerFilePath string = ".shell-ai/usage.jsonl"
+
+// Record is one answered query in the ledger.
+type Record struct {
+	Time             time.Time `json:"time"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	// Cost is in dollars, zero if the model has no pricing configured.
+	Cost float64 `json:"cost,omitempty"`
+}
+
+func NewRecord(modelConfig ModelConfig, u Usage) Record {
+	return Record{
+		Time:             time.Now(),
+		Model:            modelConfig.ModelName,
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		Cost:             Cost(modelConfig.Pricing, u),
+	}
+}
+
+// Cost prices the tokens with the model's per-million rates.
+func Cost(pricing *Pricing, u Usage) float64 {
+	if pricing == nil {
+		return 0
+	}
+	return (float64(u.PromptTokens)*pricing.InputPerMillion +
+		float64(u.CompletionTokens)*pricing.OutputPerMillion) / 1_000_000
+}
+
+// Append adds the record to the ledger file.
+func Append(record Record) error {
+	filePath, err := config.FullFilePath(ledgerFilePath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("error creating directories: %s", err)
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening usage ledger: %s", err)
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Load reads every record in the ledger. A missing ledger is empty, and
+// lines that can't be parsed are skipped.
+func Load() ([]Record, error) {
+	filePath, err := config.FullFilePath(ledgerFilePath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening usage ledger: %s", err)
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// Totals adds up a set of records.
+type Totals struct {
+	Requests         int
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+}
+
+func (t *Totals) Add(record Record) {
+	t.Requests++
+	t.PromptTokens += record.PromptTokens
+	t.CompletionTokens += record.CompletionTokens
+	t.Cost += record.Cost
+}
+
+// String formats the totals like "412 in · 28 out · $0.0010". The cost is
+// left out when nothing was priced.
+func (t Totals) String() string {
+	s := fmt.Sprintf("%d in · %d out", t.PromptTokens, t.CompletionTokens)
+	if t.Cost > 0 {
+		s += " · " + FormatCost(t.Cost)
+	}
+	return s
+}
+
+func FormatCost(cost float64) string {
+	if cost < 0.01 {
+		return fmt.Sprintf("$%.4f", cost)
+	}
+	return fmt.Sprintf("$%.2f", cost)
+}
+
+// Summary holds the totals for a period, overall and per model.
+type Summary struct {
+	Total   Totals
+	ByModel map[string]Totals
+}
+
+// Models lists the models in the summary, most expensive first.
+func (s Summary) Models() []string {
+	models := make([]string, 0, len(s.ByModel))
+	for model := range s.ByModel {
+		models = append(models, model)
+	}
+	sort.Slice(models, func(i, j int) bool {
+		a, b := s.ByModel[models[i]], s.ByModel[models[j]]
+		if a.Cost != b.Cost {
+			return a.Cost > b.Cost
+		}
+		return models[i] < models[j]
+	})
+	return models
+}
+
+// Since sums the records made at or after start.
+func Since(records []Record, start time.Time) Summary {
+	summary := Summary{ByModel: map[string]Totals{}}
+	for _, record := range records {
+		if record.Time.Before(start) {
+			continue
+		}
+		summary.Total.Add(record)
+		totals := summary.ByModel[record.Model]
+		totals.Add(record)
+		summary.ByModel[record.Model] = totals
+	}
+	return summary
+}
+
+// StartOfDay and StartOfMonth bound the daily and monthly totals, in local time.
+func StartOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func StartOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
@@ -1,10 +1,13 @@
 package util
 
 import (
+	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
 
+	"github.com/mattn/go-isatty"
 	"github.com/mattn/go-tty"
 )
 
@@ -61,6 +64,11 @@ func ExtractFirstCodeBlock(s string) (content string, isOnlyCode bool) {
 	return
 }
 
+// IsTerminal reports whether f is attached to a terminal.
+func IsTerminal(f *os.File) bool {
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
+
 func GetTermSafeMaxWidth() int {
 	maxWidth := TermMaxWidth
 	termWidth, err := getTermWidth()
@@ -80,8 +88,24 @@ func getTermWidth() (width int, err error) {
 	return width, err
 }
 
-func IsLikelyBillingError(s string) bool {
-	return strings.Contains(s, "429 Too Many Requests")
+func Clamp(v, low, high int) int {
+	if v < low {
+		return low
+	}
+	if v > high {
+		return high
+	}
+	return v
+}
+
+// Truncate shortens s to length runes, ending it with "..." when it's cut.
+// Lengths too short to fit anything before the dots leave s as it is.
+func Truncate(s string, length int) string {
+	runes := []rune(s)
+	if length < 4 || len(runes) <= length {
+		return s
+	}
+	return string(runes[:length-3]) + "..."
 }
 
 func OpenBrowser(url string) error {
@@ -98,3 +122,21 @@ func OpenBrowser(url string) error {
 
 	return cmd.Start()
 }
+
+// ShellCommand builds a command that runs the given snippet in the user's
+// shell, falling back to sh (or PowerShell on Windows) when $SHELL is unset.
+func ShellCommand(command string) *exec.Cmd {
+	shell := os.Getenv("SHELL")
+	if shell == "" && runtime.GOOS == "windows" {
+		return exec.Command("powershell", "-NoProfile", "-Command", command)
+	}
+	if shell == "" {
+		shell = "sh"
+	}
+	return exec.Command(shell, "-c", command)
+}
+
+// ShellName returns the base name of the shell ShellCommand will use.
+func ShellName() string {
+	return filepath.Base(ShellCommand("").Path)
+}